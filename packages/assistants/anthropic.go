@@ -1,6 +1,7 @@
 package assistants
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -8,18 +9,24 @@ import (
 	"github.com/felipem1210/freetalkbot/packages/common"
 )
 
+func init() {
+	Register("anthropic", []string{"ANTHROPIC_TOKEN", "ANTHROPIC_URL"}, func() (Assistant, error) {
+		return Anthropic{}, nil
+	})
+}
+
 // Define a structure to match the JSON response
 type Anthropic struct {
 	Request   common.PostHttpReq
 	Responses common.Responses
 }
 
-func (a Anthropic) sendPrompt() (common.Responses, error) {
+func (a Anthropic) sendPrompt(ctx context.Context) (common.Responses, error) {
 	anthropicResponses := a.Responses
 	requestBody := a.Request.JsonBody
 	slog.Debug(fmt.Sprintf("Message for anthropic: %v", requestBody["message"]), "jid", requestBody["sender"])
 	a.Request.Url = os.Getenv("ANTHROPIC_URL")
-	body, err := a.Request.SendPost("json")
+	body, err := a.Request.SendPostWithContext(ctx, "json")
 	if err != nil {
 		return anthropicResponses, fmt.Errorf("error sending message: %s", err)
 	}
@@ -31,9 +38,9 @@ func (a Anthropic) sendPrompt() (common.Responses, error) {
 	return anthropicResponses, nil
 }
 
-func (a Anthropic) Interact(sender string, message string) (common.Responses, error) {
-	a.Request.JsonBody = map[string]string{"sender": sender, "text": message}
-	responses, err := a.sendPrompt()
+func (a Anthropic) Interact(ctx context.Context, sender string, language string, message common.Message) (common.Responses, error) {
+	a.Request.JsonBody = map[string]string{"sender": sender, "text": message.Content()}
+	responses, err := a.sendPrompt(ctx)
 	if err != nil {
 		return nil, err
 	}
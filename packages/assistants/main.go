@@ -1,31 +1,92 @@
 package assistants
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"sort"
+	"sync"
 
 	"github.com/felipem1210/freetalkbot/packages/common"
 )
 
-func HandleAssistant(language string, sender string, message string) (common.Responses, error) {
-	var response common.Responses
-	var err error
-	switch os.Getenv("ASSISTANT_TOOL") {
-	case "anthropic":
-		anthropicHandler := Anthropic{}
-		response, err = anthropicHandler.Interact(sender, message)
-		if err != nil {
-			return nil, err
-		}
-
-	case "rasa":
-		rasaHandler := Rasa{
-			MessageLanguage: language,
-			RasaLanguage:    os.Getenv("ASSISTANT_LANGUAGE"),
-		}
-		response, err = rasaHandler.Interact(sender, message)
-		if err != nil {
-			return nil, err
-		}
+// Assistant is implemented by every backend able to answer the messages of the users
+type Assistant interface {
+	Interact(ctx context.Context, sender string, language string, message common.Message) (common.Responses, error)
+}
+
+// Factory creates a new instance of an assistant
+type Factory func() (Assistant, error)
+
+type registration struct {
+	factory     Factory
+	requiredEnv []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]registration{}
+
+	defaultOnce      sync.Once
+	defaultAssistant Assistant
+	defaultErr       error
+)
+
+// Register makes an assistant available under the given name, which is the value used in ASSISTANT_TOOL.
+// requiredEnv are the env vars that must be set to use the assistant.
+func Register(name string, requiredEnv []string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("assistants: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("assistants: Register called twice for assistant " + name)
+	}
+	registry[name] = registration{factory: factory, requiredEnv: requiredEnv}
+}
+
+// Names returns the sorted list of the registered assistants
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RequiredEnv returns the env vars needed by the assistant registered with the given name
+func RequiredEnv(name string) ([]string, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	reg, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown assistant %q", name)
+	}
+	return reg.requiredEnv, nil
+}
+
+// New creates the assistant registered with the given name
+func New(name string) (Assistant, error) {
+	registryMu.RLock()
+	reg, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown assistant %q", name)
+	}
+	return reg.factory()
+}
+
+// HandleAssistant sends the message to the assistant configured in ASSISTANT_TOOL
+func HandleAssistant(ctx context.Context, language string, sender string, message common.Message) (common.Responses, error) {
+	defaultOnce.Do(func() {
+		defaultAssistant, defaultErr = New(os.Getenv("ASSISTANT_TOOL"))
+	})
+	if defaultErr != nil {
+		return nil, defaultErr
 	}
-	return response, nil
+	return defaultAssistant.Interact(ctx, sender, language, message)
 }
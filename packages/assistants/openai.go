@@ -0,0 +1,169 @@
+package assistants
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/history"
+	"github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	Register("openai", []string{"OPENAI_ASSISTANT_URL", "OPENAI_ASSISTANT_MODEL"}, newOpenAI)
+}
+
+// OpenAI talks directly with any server implementing the OpenAI chat completions API,
+// like OpenAI, Ollama, vLLM or llama.cpp server.
+type OpenAI struct {
+	Client       *openai.Client
+	Model        string
+	SystemPrompt string
+	Temperature  float32
+	History      history.Store
+	// RichResponses asks the model to answer with JSON, so it can offer buttons, images and attachments
+	RichResponses bool
+}
+
+// richResponsesPrompt describes the JSON answer expected when OPENAI_ASSISTANT_RICH_RESPONSES is true
+const richResponsesPrompt = `Answer only with a JSON object with the field "text", your answer for the user. ` +
+	`Optionally add "buttons", a list of options the user can choose, each one with "title" and "payload", ` +
+	`"image", the URL of an image, and "attachment", the URL of a document.`
+
+// markdownImage matches an image in markdown, like ![receipt](https://example.com/receipt.png)
+var markdownImage = regexp.MustCompile(`!\[[^\]]*\]\((https?://[^)\s]+)\)`)
+
+func newOpenAI() (Assistant, error) {
+	config := openai.DefaultConfig(os.Getenv("OPENAI_ASSISTANT_TOKEN"))
+	config.BaseURL = os.Getenv("OPENAI_ASSISTANT_URL")
+
+	var temperature float64
+	if t := os.Getenv("OPENAI_ASSISTANT_TEMPERATURE"); t != "" {
+		var err error
+		temperature, err = strconv.ParseFloat(t, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for OPENAI_ASSISTANT_TEMPERATURE: %s", err)
+		}
+	}
+
+	store, err := history.NewFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenAI{
+		History:       store,
+		Client:        openai.NewClientWithConfig(config),
+		Model:         os.Getenv("OPENAI_ASSISTANT_MODEL"),
+		SystemPrompt:  os.Getenv("OPENAI_ASSISTANT_SYSTEM_PROMPT"),
+		Temperature:   float32(temperature),
+		RichResponses: os.Getenv("OPENAI_ASSISTANT_RICH_RESPONSES") == "true",
+	}, nil
+}
+
+// systemMessage builds the system prompt, asking the model to answer in the language of the user
+func (o OpenAI) systemMessage(language string) string {
+	prompt := o.SystemPrompt
+	if language != "" && language != "none" {
+		prompt = fmt.Sprintf("%s\nAlways answer in the language with ISO 639-1 code %q.", prompt, language)
+	}
+	if o.RichResponses {
+		prompt = fmt.Sprintf("%s\n%s", prompt, richResponsesPrompt)
+	}
+	return strings.TrimSpace(prompt)
+}
+
+// parseAnswer reads the answer of the model. It is used as it is when it is a JSON object with the format of the
+// responses, otherwise it is the text of the response and the first image in markdown is sent as image.
+func parseAnswer(sender string, answer string) common.Response {
+	trimmed := strings.TrimSpace(answer)
+	trimmed = strings.TrimPrefix(trimmed, "```json")
+	trimmed = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(trimmed, "```"), "```"))
+	var r common.Response
+	if strings.HasPrefix(trimmed, "{") && json.Unmarshal([]byte(trimmed), &r) == nil && r.Text != "" {
+		r.RecipientId = sender
+		return r
+	}
+
+	r = common.Response{RecipientId: sender, Text: answer}
+	if m := markdownImage.FindStringSubmatch(answer); m != nil {
+		r.Image = m[1]
+		r.Text = strings.TrimSpace(strings.Replace(answer, m[0], "", 1))
+	}
+	return r
+}
+
+// maxDocumentLength is the maximum number of bytes of a text document sent to the model
+const maxDocumentLength = 32 * 1024
+
+// userMessage builds the message of the user. Images are sent to the model, which must support vision,
+// and the content of text documents is added to the message.
+func userMessage(m common.Message) openai.ChatCompletionMessage {
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: m.Content()}
+	switch {
+	case m.Media == nil || len(m.Media.Data) == 0:
+	case m.Media.IsImage():
+		message.Content = ""
+		message.MultiContent = []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeText, Text: m.Content()},
+			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{
+				URL: fmt.Sprintf("data:%s;base64,%s", m.Media.MimeType, base64.StdEncoding.EncodeToString(m.Media.Data)),
+			}},
+		}
+	case m.Media.IsText():
+		document := m.Media.Data
+		if len(document) > maxDocumentLength {
+			document = document[:maxDocumentLength]
+		}
+		message.Content = fmt.Sprintf("%s\n\n%s", message.Content, document)
+	}
+	return message
+}
+
+func (o OpenAI) Interact(ctx context.Context, sender string, language string, m common.Message) (common.Responses, error) {
+	message := m.Content()
+	slog.Debug(fmt.Sprintf("Message for openai: %v", message), "jid", sender)
+	var messages []openai.ChatCompletionMessage
+	if systemMessage := o.systemMessage(language); systemMessage != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: systemMessage})
+	}
+	turns, err := o.History.Get(ctx, sender)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Error reading conversation history: %s", err), "jid", sender)
+	}
+	for _, t := range turns {
+		messages = append(messages, openai.ChatCompletionMessage{Role: t.Role, Content: t.Content})
+	}
+	messages = append(messages, userMessage(m))
+
+	resp, err := o.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:       o.Model,
+		Messages:    messages,
+		Temperature: o.Temperature,
+		User:        sender,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error sending message: %s", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("error handling response body: no choices returned")
+	}
+
+	answer := resp.Choices[0].Message.Content
+	err = o.History.Append(ctx, sender,
+		history.Turn{Role: history.RoleUser, Content: message},
+		history.Turn{Role: history.RoleAssistant, Content: answer},
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Error storing conversation history: %s", err), "jid", sender)
+	}
+
+	return common.Responses{parseAnswer(sender, answer)}, nil
+}
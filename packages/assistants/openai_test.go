@@ -0,0 +1,206 @@
+package assistants
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/history"
+	"github.com/sashabaranov/go-openai"
+)
+
+// chatCompletionsStub answers /v1/chat/completions with the answers in order, keeping the requests received
+type chatCompletionsStub struct {
+	*httptest.Server
+	answers  []string
+	requests []openai.ChatCompletionRequest
+}
+
+func newChatCompletionsStub(t *testing.T, answers ...string) *chatCompletionsStub {
+	stub := &chatCompletionsStub{answers: answers}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request body: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		answer := stub.answers[len(stub.requests)%len(stub.answers)]
+		stub.requests = append(stub.requests, req)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model: req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: answer},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *chatCompletionsStub) assistant(rich bool) OpenAI {
+	config := openai.DefaultConfig("token")
+	config.BaseURL = stub.URL + "/v1"
+	return OpenAI{
+		Client:        openai.NewClientWithConfig(config),
+		Model:         "test-model",
+		SystemPrompt:  "You are a test.",
+		History:       history.NewMemoryStore(history.Limits{}),
+		RichResponses: rich,
+	}
+}
+
+func TestOpenAISendsHistory(t *testing.T) {
+	stub := newChatCompletionsStub(t, "Hello Ana!", "Your name is Ana.")
+	o := stub.assistant(false)
+	ctx := context.Background()
+
+	if _, err := o.Interact(ctx, "ana", "en", common.Message{Text: "My name is Ana"}); err != nil {
+		t.Fatal(err)
+	}
+	responses, err := o.Interact(ctx, "ana", "en", common.Message{Text: "What is my name?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0].Text != "Your name is Ana." || responses[0].RecipientId != "ana" {
+		t.Errorf("unexpected responses %+v", responses)
+	}
+
+	if len(stub.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(stub.requests))
+	}
+	req := stub.requests[1]
+	if req.Model != "test-model" || req.User != "ana" {
+		t.Errorf("unexpected model %q or user %q", req.Model, req.User)
+	}
+	want := []struct{ role, content string }{
+		{openai.ChatMessageRoleSystem, ""},
+		{openai.ChatMessageRoleUser, "My name is Ana"},
+		{openai.ChatMessageRoleAssistant, "Hello Ana!"},
+		{openai.ChatMessageRoleUser, "What is my name?"},
+	}
+	if len(req.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d: %+v", len(req.Messages), len(want), req.Messages)
+	}
+	for i, w := range want {
+		m := req.Messages[i]
+		if m.Role != w.role || (w.content != "" && m.Content != w.content) {
+			t.Errorf("message %d is %s %q, want %s %q", i, m.Role, m.Content, w.role, w.content)
+		}
+	}
+
+	// The history is kept per sender
+	if _, err := o.Interact(ctx, "bob", "en", common.Message{Text: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(stub.requests[2].Messages); n != 2 {
+		t.Errorf("got %d messages for another sender, want the system prompt and the message", n)
+	}
+}
+
+func TestOpenAIResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		rich   bool
+		answer string
+		want   common.Response
+	}{
+		{
+			name:   "plain text",
+			answer: "Just text, no JSON.",
+			want:   common.Response{Text: "Just text, no JSON."},
+		},
+		{
+			name:   "rich JSON",
+			rich:   true,
+			answer: `{"text": "Choose one", "buttons": [{"title": "Yes", "payload": "/affirm"}], "image": "https://example.com/a.png"}`,
+			want: common.Response{
+				Text:    "Choose one",
+				Buttons: common.Buttons{{Title: "Yes", Payload: "/affirm"}},
+				Image:   "https://example.com/a.png",
+			},
+		},
+		{
+			name:   "rich JSON in a code block",
+			rich:   true,
+			answer: "```json\n{\"text\": \"Fenced\", \"attachment\": \"https://example.com/doc.pdf\"}\n```",
+			want: common.Response{
+				Text:       "Fenced",
+				Attachment: &common.Attachment{Type: "file", URL: "https://example.com/doc.pdf"},
+			},
+		},
+		{
+			name:   "plain text when JSON was asked",
+			rich:   true,
+			answer: "Sorry, here is your answer {not json}",
+			want:   common.Response{Text: "Sorry, here is your answer {not json}"},
+		},
+		{
+			name:   "JSON without text",
+			rich:   true,
+			answer: `{"buttons": []}`,
+			want:   common.Response{Text: `{"buttons": []}`},
+		},
+		{
+			name:   "markdown image",
+			answer: "Here is your receipt ![receipt](https://example.com/receipt.png)",
+			want:   common.Response{Text: "Here is your receipt", Image: "https://example.com/receipt.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newChatCompletionsStub(t, tt.answer)
+			responses, err := stub.assistant(tt.rich).Interact(context.Background(), "ana", "", common.Message{Text: "Hi"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(responses) != 1 {
+				t.Fatalf("got %d responses, want 1", len(responses))
+			}
+			tt.want.RecipientId = "ana"
+			got, _ := json.Marshal(responses[0])
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+
+			system := stub.requests[0].Messages[0]
+			if system.Role != openai.ChatMessageRoleSystem {
+				t.Fatalf("first message is %s, want the system prompt", system.Role)
+			}
+			if asked := strings.Contains(system.Content, richResponsesPrompt); asked != tt.rich {
+				t.Errorf("system prompt asks for JSON: %v, want %v", asked, tt.rich)
+			}
+		})
+	}
+}
+
+func TestOpenAIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"message": "overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	config := openai.DefaultConfig("token")
+	config.BaseURL = srv.URL + "/v1"
+	config.HTTPClient = srv.Client()
+	store := history.NewMemoryStore(history.Limits{})
+	o := OpenAI{Client: openai.NewClientWithConfig(config), Model: "test-model", History: store}
+
+	if _, err := o.Interact(context.Background(), "ana", "", common.Message{Text: "Hi"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	// A failed interaction is not remembered
+	if turns, _ := store.Get(context.Background(), "ana"); len(turns) != 0 {
+		t.Errorf("got %d turns in the history, want 0", len(turns))
+	}
+}
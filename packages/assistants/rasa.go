@@ -1,6 +1,7 @@
 package assistants
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -12,12 +13,20 @@ import (
 	"github.com/felipem1210/freetalkbot/packages/common"
 )
 
+func init() {
+	Register("rasa", []string{"RASA_URL", "ASSISTANT_LANGUAGE", "CALLBACK_SERVER_URL", "RASA_ACTIONS_SERVER_URL"}, func() (Assistant, error) {
+		return Rasa{RasaLanguage: os.Getenv("ASSISTANT_LANGUAGE"), DtmfIntent: os.Getenv("RASA_DTMF_INTENT")}, nil
+	})
+}
+
 // Define a structure to match the JSON response
 type Rasa struct {
 	Request         common.PostHttpReq
 	Responses       common.Responses
 	MessageLanguage string
 	RasaLanguage    string
+	// DtmfIntent is the intent triggered with the keys pressed by the caller, in the entity digits
+	DtmfIntent string
 }
 
 func chooseUri(text string) string {
@@ -29,13 +38,13 @@ func chooseUri(text string) string {
 	}
 }
 
-func (r Rasa) sendPrompt() (common.Responses, error) {
+func (r Rasa) sendPrompt(ctx context.Context) (common.Responses, error) {
 	rasaResponses := r.Responses
 	requestBody := r.Request.JsonBody
 	slog.Debug(fmt.Sprintf("Message for rasa: %v", requestBody["message"]), "jid", requestBody["sender"])
 	rasaUri := fmt.Sprintf("%s/%s", os.Getenv("RASA_URL"), chooseUri(requestBody["message"]))
 	r.Request.Url = rasaUri
-	body, err := r.Request.SendPost("json")
+	body, err := r.Request.SendPostWithContext(ctx, "json")
 	if err != nil {
 		return rasaResponses, fmt.Errorf("error sending message: %s", err)
 	}
@@ -51,20 +60,33 @@ func (r Rasa) sendPrompt() (common.Responses, error) {
 				responseStruct.Text, _ = gt.Translate(responseStruct.Text, r.RasaLanguage, r.MessageLanguage)
 				// Add the translated text to the response and remove the original text
 				rasaResponses[i].Text = responseStruct.Text
+				// The titles are translated too, the payloads are kept to be sent back to rasa
+				for j, button := range responseStruct.Buttons {
+					if title, err := gt.Translate(button.Title, r.RasaLanguage, r.MessageLanguage); err == nil && title != "" {
+						rasaResponses[i].Buttons[j].Title = title
+					}
+				}
 			}
 		}
 	}
 	return rasaResponses, nil
 }
 
-func (r Rasa) Interact(sender string, message string) (common.Responses, error) {
-	if !strings.Contains(r.MessageLanguage, r.RasaLanguage) && r.RasaLanguage != r.MessageLanguage {
+func (r Rasa) Interact(ctx context.Context, sender string, language string, m common.Message) (common.Responses, error) {
+	r.MessageLanguage = language
+	message := m.Content()
+	if m.Digits != "" && r.DtmfIntent != "" {
+		message = fmt.Sprintf(`/%s{"digits": %q}`, r.DtmfIntent, m.Digits)
+	}
+	// Payloads of buttons, like /inform{"slot": "value"}, are never translated
+	isPayload := strings.HasPrefix(message, "/")
+	if !isPayload && !strings.Contains(r.MessageLanguage, r.RasaLanguage) && r.RasaLanguage != r.MessageLanguage {
 		message, _ = gt.Translate(message, r.MessageLanguage, r.RasaLanguage)
 		slog.Debug(fmt.Sprintf("translated message: %s", message), "jid", sender)
 	}
 
 	r.Request.JsonBody = map[string]string{"sender": sender, "message": message}
-	responses, err := r.sendPrompt()
+	responses, err := r.sendPrompt(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,144 @@
+package audiofork
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+// fork carries the audio of a call through the WebSocket of the audio fork. The audio is L16 mono at
+// sampleRate, 8kHz or 16kHz, in binary messages in both directions, for the forks streaming the audio
+// received back to the call. mod_audio_fork plays clips instead, see clipFork.
+type fork struct {
+	conn       *websocket.Conn
+	id         string
+	sampleRate int
+	// writeMu serializes the writes, as the audio and the hangup are sent from different goroutines
+	writeMu sync.Mutex
+}
+
+// ReadAudio returns the next audio of the caller at 8kHz
+func (f *fork) ReadAudio() ([]byte, error) {
+	for {
+		kind, data, err := f.conn.ReadMessage()
+		if err != nil {
+			// The caller hung up when the WebSocket is closed, cleanly or not
+			slog.Debug(fmt.Sprintf("audio fork closed: %v", err), "callId", f.id)
+			return nil, io.EOF
+		}
+		switch kind {
+		case websocket.BinaryMessage:
+			if f.sampleRate != voice.SampleRate {
+				data = downsample(data)
+			}
+			return data, nil
+		case websocket.TextMessage:
+			slog.Debug(fmt.Sprintf("message from audio fork: %s", data), "callId", f.id)
+		}
+	}
+}
+
+// WriteAudio sends the audio to the caller at the sample rate of the fork
+func (f *fork) WriteAudio(frame []byte) error {
+	if f.sampleRate != voice.SampleRate {
+		frame = upsample(frame)
+	}
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return f.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// clipFork is the fork of mod_audio_fork, which doesn't play the binary messages: the audio is sent as a clip in
+// a playAudio message, saved by mod_audio_fork to a file announced with the mod_audio_fork::play_audio event, and
+// killAudio stops it
+type clipFork struct {
+	*fork
+}
+
+// command is a message of the bot to mod_audio_fork
+type command struct {
+	Type string     `json:"type"`
+	Data *audioClip `json:"data,omitempty"`
+}
+
+// audioClip is the audio of a playAudio message, L16 base64 encoded
+type audioClip struct {
+	AudioContentType string `json:"audioContentType"`
+	SampleRate       int    `json:"sampleRate"`
+	AudioContent     string `json:"audioContent"`
+}
+
+// PlayClip sends the audio to mod_audio_fork to be played at the sample rate of the fork
+func (f clipFork) PlayClip(pcm []byte) error {
+	if f.sampleRate != voice.SampleRate {
+		pcm = upsample(pcm)
+	}
+	return f.send(command{Type: "playAudio", Data: &audioClip{
+		AudioContentType: "raw",
+		SampleRate:       f.sampleRate,
+		AudioContent:     base64.StdEncoding.EncodeToString(pcm),
+	}})
+}
+
+// StopClips asks mod_audio_fork to stop the audio being played
+func (f clipFork) StopClips() error {
+	return f.send(command{Type: "killAudio"})
+}
+
+// WriteAudio is not used, the audio is played with PlayClip
+func (f clipFork) WriteAudio(frame []byte) error {
+	return fmt.Errorf("mod_audio_fork only plays clips")
+}
+
+func (f *fork) send(c command) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return f.conn.WriteJSON(c)
+}
+
+// Hangup asks mod_audio_fork to close the fork and closes the WebSocket
+func (f *fork) Hangup() error {
+	f.send(command{Type: "disconnect"})
+	f.writeMu.Lock()
+	f.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+	f.writeMu.Unlock()
+	return f.conn.Close()
+}
+
+// downsample converts 16kHz L16 audio to 8kHz averaging each pair of samples
+func downsample(data []byte) []byte {
+	out := make([]byte, len(data)/4*2)
+	for i := 0; i+4 <= len(data); i += 4 {
+		a := int32(int16(binary.LittleEndian.Uint16(data[i:])))
+		b := int32(int16(binary.LittleEndian.Uint16(data[i+2:])))
+		binary.LittleEndian.PutUint16(out[i/2:], uint16(int16((a+b)/2)))
+	}
+	return out
+}
+
+// upsample converts 8kHz L16 audio to 16kHz interpolating a sample between each pair
+func upsample(data []byte) []byte {
+	samples := len(data) / 2
+	out := make([]byte, samples*4)
+	for i := 0; i < samples; i++ {
+		a := int32(int16(binary.LittleEndian.Uint16(data[2*i:])))
+		b := a
+		if i+1 < samples {
+			b = int32(int16(binary.LittleEndian.Uint16(data[2*i+2:])))
+		}
+		binary.LittleEndian.PutUint16(out[4*i:], uint16(int16(a)))
+		binary.LittleEndian.PutUint16(out[4*i+2:], uint16(int16((a+b)/2)))
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package audiofork
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	voice8k  = voice.SampleRate
+	voice16k = 2 * voice.SampleRate
+)
+
+// l16 encodes the samples as L16 audio
+func l16(samples ...int16) []byte {
+	data := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+	return data
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func([]byte) []byte
+		in   []byte
+		want []byte
+	}{
+		{name: "downsample", fn: downsample, in: l16(100, 200, -300, -500, 32767, 32767), want: l16(150, -400, 32767)},
+		{name: "downsample odd sample", fn: downsample, in: l16(100, 200, 300), want: l16(150)},
+		{name: "downsample empty", fn: downsample, in: nil, want: []byte{}},
+		{name: "upsample", fn: upsample, in: l16(100, 200, -300), want: l16(100, 150, 200, -50, -300, -300)},
+		{name: "upsample extremes", fn: upsample, in: l16(-32768, 32767), want: l16(-32768, 0, 32767, 32767)},
+		{name: "upsample odd byte", fn: upsample, in: []byte{1, 0, 7}, want: l16(1, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fn(tt.in); string(got) != string(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Downsampling the upsampled audio averages each sample with the interpolated one after it
+	audio := l16(0, 1000, 2000, 1000, 0, -1000)
+	if got := downsample(upsample(audio)); string(got) != string(l16(250, 1250, 1750, 750, -250, -1000)) {
+		t.Errorf("got %v after upsampling and downsampling", got)
+	}
+}
+
+func TestReadAudio(t *testing.T) {
+	f, client := connect(t, voice16k)
+	send(t, client, websocket.TextMessage, []byte(`{"type":"transcription"}`))
+	send(t, client, websocket.BinaryMessage, l16(100, 200, 300, 500))
+
+	// The text messages are skipped and the audio is converted to 8kHz
+	frame, err := f.ReadAudio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame) != string(l16(150, 400)) {
+		t.Errorf("got %v, want the audio at 8kHz", frame)
+	}
+
+	f, client = connect(t, voice8k)
+	send(t, client, websocket.BinaryMessage, l16(100, 200))
+	if frame, err := f.ReadAudio(); err != nil || string(frame) != string(l16(100, 200)) {
+		t.Errorf("got %v and error %v, want the audio as received", frame, err)
+	}
+
+	// The caller hung up when the WebSocket is closed
+	client.Close()
+	if _, err := f.ReadAudio(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v after the WebSocket closed, want EOF", err)
+	}
+}
+
+func TestWriteAudio(t *testing.T) {
+	f, client := connect(t, voice16k)
+	if err := f.WriteAudio(l16(100, 200)); err != nil {
+		t.Fatal(err)
+	}
+	kind, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != websocket.BinaryMessage || string(data) != string(l16(100, 150, 200, 200)) {
+		t.Errorf("got message %d %v, want the binary audio at 16kHz", kind, data)
+	}
+}
+
+func TestPlayClip(t *testing.T) {
+	for _, sampleRate := range []int{voice8k, voice16k} {
+		f, client := connect(t, sampleRate)
+		clips := clipFork{f}
+		if err := clips.PlayClip(l16(100, 200)); err != nil {
+			t.Fatal(err)
+		}
+		var c command
+		if err := client.ReadJSON(&c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Type != "playAudio" || c.Data == nil || c.Data.AudioContentType != "raw" || c.Data.SampleRate != sampleRate {
+			t.Fatalf("got %+v, want a raw playAudio at %dHz", c, sampleRate)
+		}
+		audio, err := base64.StdEncoding.DecodeString(c.Data.AudioContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := l16(100, 200)
+		if sampleRate == voice16k {
+			want = l16(100, 150, 200, 200)
+		}
+		if string(audio) != string(want) {
+			t.Errorf("got audio %v at %dHz, want %v", audio, sampleRate, want)
+		}
+
+		if err := clips.StopClips(); err != nil {
+			t.Fatal(err)
+		}
+		c = command{}
+		if err := client.ReadJSON(&c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Type != "killAudio" || c.Data != nil {
+			t.Errorf("got %+v, want killAudio", c)
+		}
+
+		// mod_audio_fork doesn't play the binary messages
+		if err := clips.WriteAudio(l16(100, 200)); err == nil {
+			t.Error("audio written as binary message")
+		}
+	}
+}
+
+func TestHangup(t *testing.T) {
+	f, client := connect(t, voice8k)
+	if err := (clipFork{f}).Hangup(); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c map[string]any
+	if err := json.Unmarshal(data, &c); err != nil || len(c) != 1 || c["type"] != "disconnect" {
+		t.Errorf("got %s, want disconnect", data)
+	}
+	_, _, err = client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got %v after the hangup, want a normal close", err)
+	}
+}
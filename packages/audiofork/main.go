@@ -0,0 +1,209 @@
+package audiofork
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultAddr = ":8086"
+	// startTimeout is how long the fork has to send its metadata after connecting
+	startTimeout = 10 * time.Second
+)
+
+// metadata is the JSON header sent by the fork as the first text message. With mod_audio_fork it is the
+// metadata argument of uuid_audio_fork.
+type metadata struct {
+	CallId     string `json:"callId"`
+	Uuid       string `json:"uuid"`
+	Language   string `json:"language"`
+	SampleRate int    `json:"sampleRate"`
+}
+
+// Server is the audiofork channel, it answers the calls sent by FreeSWITCH mod_audio_fork, or any fork sending
+// raw L16 audio in binary WebSocket messages
+type Server struct {
+	http     *http.Server
+	upgrader websocket.Upgrader
+	bot      *voice.Bot
+	calls    sync.WaitGroup
+	// token is the token of the forks in the URL. Without it any fork is answered.
+	token string
+	// binaryAudio sends the audio to the forks in binary messages instead of the playAudio clips of mod_audio_fork
+	binaryAudio bool
+}
+
+// New creates the audiofork channel with the shared engines, the TTS engine is mandatory
+func New(engines channels.Engines) (*Server, error) {
+	bot, err := voice.NewBot(engines)
+	if err != nil {
+		return nil, err
+	}
+	addr := os.Getenv("AUDIOFORK_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	srv := &Server{bot: bot, token: os.Getenv("AUDIOFORK_TOKEN")}
+	if srv.token == "" {
+		if os.Getenv("AUDIOFORK_INSECURE") != "true" {
+			return nil, fmt.Errorf("AUDIOFORK_TOKEN must be set to use the audiofork channel, or AUDIOFORK_INSECURE=true to answer any audio fork")
+		}
+		slog.Warn("AUDIOFORK_INSECURE is true, any audio fork is answered")
+	}
+	switch playback := os.Getenv("AUDIOFORK_PLAYBACK"); playback {
+	case "", "playaudio":
+	case "binary":
+		srv.binaryAudio = true
+	default:
+		return nil, fmt.Errorf("invalid AUDIOFORK_PLAYBACK %s, valid values are playaudio and binary", playback)
+	}
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.Default()
+	router.GET("/audio", srv.handleFork)
+	srv.http = &http.Server{Addr: addr, Handler: router}
+	return srv, nil
+}
+
+func (srv *Server) Name() string {
+	return "audiofork"
+}
+
+// Start serves the audio forks until ctx is done
+func (srv *Server) Start(ctx context.Context) error {
+	// The calls end when ctx is done
+	srv.http.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		srv.shutdown()
+	}()
+	go srv.bot.SweepRecordings(ctx)
+
+	slog.Info(fmt.Sprintf("Starting audiofork server on %s", srv.http.Addr))
+	if err := srv.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop shuts down the server and waits until the calls in progress end
+func (srv *Server) Stop() error {
+	err := srv.shutdown()
+	srv.calls.Wait()
+	return err
+}
+
+func (srv *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.http.Shutdown(ctx)
+}
+
+// handleFork answers the call of the audio fork until the caller hangs up
+func (srv *Server) handleFork(c *gin.Context) {
+	if !srv.authorized(c.Request) {
+		slog.Warn(fmt.Sprintf("unauthorized audio fork from %s", c.ClientIP()))
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	conn, err := srv.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to upgrade to websocket: %v", err))
+		return
+	}
+	srv.calls.Add(1)
+	defer srv.calls.Done()
+	defer conn.Close()
+
+	ctx := c.Request.Context()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetReadDeadline(time.Now().Add(startTimeout))
+	m, err := readMetadata(conn)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("audio fork closed before sending its metadata: %v", err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err := m.complete(c.Request.URL.Query()); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	slog.Info(fmt.Sprintf("Audio fork started with %dHz audio", m.SampleRate), "callId", m.CallId)
+	f := &fork{conn: conn, id: m.CallId, sampleRate: m.SampleRate}
+	if srv.binaryAudio {
+		srv.bot.Handle(ctx, m.CallId, m.Language, f)
+	} else {
+		srv.bot.Handle(ctx, m.CallId, m.Language, clipFork{f})
+	}
+}
+
+// authorized checks that the fork has the AUDIOFORK_TOKEN in the token parameter of the URL. Any fork is
+// authorized when AUDIOFORK_INSECURE is true.
+func (srv *Server) authorized(r *http.Request) bool {
+	if srv.token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1
+}
+
+// complete sets the fields missing in the metadata: the language and the sample rate of the query, 8kHz by
+// default, and the id of the call, the uuid or a new one when callId is not set
+func (m *metadata) complete(query url.Values) error {
+	if m.Language == "" {
+		m.Language = query.Get("language")
+	}
+	if m.SampleRate == 0 {
+		m.SampleRate, _ = strconv.Atoi(query.Get("sample_rate"))
+	}
+	if m.SampleRate == 0 {
+		m.SampleRate = voice.SampleRate
+	}
+	if m.SampleRate != voice.SampleRate && m.SampleRate != 2*voice.SampleRate {
+		return fmt.Errorf("unsupported sample rate %d, valid values are 8000 and 16000", m.SampleRate)
+	}
+	if m.CallId == "" {
+		m.CallId = m.Uuid
+	}
+	if m.CallId == "" {
+		m.CallId = uuid.Must(uuid.NewV4()).String()
+	}
+	return nil
+}
+
+// readMetadata reads the JSON header of the fork. Audio received before it is discarded.
+func readMetadata(conn *websocket.Conn) (metadata, error) {
+	var m metadata
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			return m, err
+		}
+		if kind == websocket.TextMessage {
+			if err := json.Unmarshal(data, &m); err != nil {
+				slog.Warn(fmt.Sprintf("audio fork metadata is not JSON: %v", err))
+			}
+			return m, nil
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package audiofork
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/gorilla/websocket"
+)
+
+// silentTTS says nothing, the channel only needs an engine to start
+type silentTTS struct{}
+
+func (silentTTS) Synthesize(ctx context.Context, text string, language string) ([]byte, int, error) {
+	return nil, 8000, nil
+}
+
+func newTestServer(t *testing.T, env map[string]string) (*Server, error) {
+	for _, name := range []string{"AUDIOFORK_TOKEN", "AUDIOFORK_INSECURE", "AUDIOFORK_PLAYBACK"} {
+		t.Setenv(name, env[name])
+	}
+	return New(channels.Engines{TTS: silentTTS{}})
+}
+
+func TestNew(t *testing.T) {
+	if _, err := newTestServer(t, nil); err == nil {
+		t.Fatal("the channel started without token")
+	}
+	if _, err := newTestServer(t, map[string]string{"AUDIOFORK_INSECURE": "true"}); err != nil {
+		t.Errorf("the channel didn't start with AUDIOFORK_INSECURE=true: %v", err)
+	}
+	srv, err := newTestServer(t, map[string]string{"AUDIOFORK_TOKEN": "token", "AUDIOFORK_PLAYBACK": "binary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !srv.binaryAudio {
+		t.Error("AUDIOFORK_PLAYBACK=binary doesn't send the audio in binary messages")
+	}
+	if _, err := newTestServer(t, map[string]string{"AUDIOFORK_TOKEN": "token", "AUDIOFORK_PLAYBACK": "file"}); err == nil {
+		t.Error("invalid AUDIOFORK_PLAYBACK accepted")
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		url        string
+		authorized bool
+	}{
+		{name: "token", env: map[string]string{"AUDIOFORK_TOKEN": "token"}, url: "/audio?token=token", authorized: true},
+		{name: "wrong token", env: map[string]string{"AUDIOFORK_TOKEN": "token"}, url: "/audio?token=token2"},
+		{name: "no token", env: map[string]string{"AUDIOFORK_TOKEN": "token"}, url: "/audio"},
+		{name: "insecure", env: map[string]string{"AUDIOFORK_INSECURE": "true"}, url: "/audio", authorized: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := newTestServer(t, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			srv.http.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			// An authorized request fails later, as it is not a WebSocket
+			if authorized := w.Code != http.StatusUnauthorized; authorized != tt.authorized {
+				t.Errorf("got %d, want authorized %v", w.Code, tt.authorized)
+			}
+		})
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	f, client := connect(t, voice8k)
+	send(t, client, websocket.BinaryMessage, []byte{1, 2, 3, 4})
+	send(t, client, websocket.TextMessage, []byte(`{"callId":"call-1","language":"es","sampleRate":16000}`))
+	// The audio received before the metadata is discarded
+	m, err := readMetadata(f.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != (metadata{CallId: "call-1", Language: "es", SampleRate: 16000}) {
+		t.Errorf("got metadata %+v", m)
+	}
+
+	// A metadata which is not JSON starts the call with the default settings
+	f, client = connect(t, voice8k)
+	send(t, client, websocket.TextMessage, []byte(`call-1`))
+	if m, err := readMetadata(f.conn); err != nil || m != (metadata{}) {
+		t.Errorf("got metadata %+v and error %v, want empty metadata", m, err)
+	}
+
+	f, client = connect(t, voice8k)
+	client.Close()
+	if _, err := readMetadata(f.conn); err == nil {
+		t.Error("got metadata of a closed fork")
+	}
+}
+
+func TestCompleteMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata metadata
+		query    string
+		want     metadata
+		invalid  bool
+	}{
+		{
+			name:     "metadata",
+			metadata: metadata{CallId: "call-1", Uuid: "uuid-1", Language: "es", SampleRate: 16000},
+			query:    "language=en&sample_rate=8000",
+			want:     metadata{CallId: "call-1", Uuid: "uuid-1", Language: "es", SampleRate: 16000},
+		},
+		{
+			name:     "query",
+			metadata: metadata{Uuid: "uuid-1"},
+			query:    "language=en&sample_rate=16000",
+			want:     metadata{CallId: "uuid-1", Uuid: "uuid-1", Language: "en", SampleRate: 16000},
+		},
+		{
+			name:     "defaults",
+			metadata: metadata{CallId: "call-1"},
+			want:     metadata{CallId: "call-1", SampleRate: 8000},
+		},
+		{
+			name:     "unsupported sample rate",
+			metadata: metadata{SampleRate: 44100},
+			invalid:  true,
+		},
+		{
+			name:    "unsupported sample rate in the query",
+			query:   "sample_rate=24000",
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			m := tt.metadata
+			err := m.complete(query)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got metadata %+v, want an error", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m != tt.want {
+				t.Errorf("got metadata %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+
+	// A new id is given to the calls without id
+	var m metadata
+	if err := m.complete(nil); err != nil || m.CallId == "" {
+		t.Errorf("got call id %q and error %v, want a new id", m.CallId, err)
+	}
+}
+
+// connect returns the fork of the server side and the WebSocket of the audio fork
+func connect(t *testing.T, sampleRate int) (*fork, *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return &fork{conn: conn, id: "call-1", sampleRate: sampleRate}, client
+}
+
+func send(t *testing.T, client *websocket.Conn, kind int, data []byte) {
+	t.Helper()
+	if err := client.WriteMessage(kind, data); err != nil {
+		t.Fatal(err)
+	}
+}
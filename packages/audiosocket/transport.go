@@ -0,0 +1,88 @@
+package audiosocketserver
+
+import (
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/CyCoreSystems/audiosocket"
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/pkg/errors"
+)
+
+// kindDtmf indicates the message contains a key pressed by the caller, as an ASCII character. It is sent by
+// Asterisk 20 and newer.
+const kindDtmf audiosocket.Kind = 0x03
+
+// transport carries the audio of a call through the AudioSocket connection
+type transport struct {
+	conn           net.Conn
+	audioFormat    string
+	g711AudioCodec string
+	id             string
+	keys           chan byte
+}
+
+func newTransport(conn net.Conn, audioFormat string, g711AudioCodec string) *transport {
+	return &transport{conn: conn, audioFormat: audioFormat, g711AudioCodec: g711AudioCodec, keys: make(chan byte, 32)}
+}
+
+// callId reads the ID of the call, it is the first message sent by Asterisk
+func (t *transport) callId() (string, error) {
+	id, err := audiosocket.GetID(t.conn)
+	if err != nil {
+		return "", err
+	}
+	t.id = id.String()
+	return t.id, nil
+}
+
+// ReadAudio returns the next audio message from Asterisk, decoded to PCM when it is g711
+func (t *transport) ReadAudio() ([]byte, error) {
+	for {
+		m, err := audiosocket.NextMessage(t.conn)
+		if errors.Cause(err) == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		switch m.Kind() {
+		case audiosocket.KindHangup:
+			return nil, io.EOF
+		case kindDtmf:
+			if payload := m.Payload(); len(payload) > 0 {
+				select {
+				case t.keys <- payload[0]:
+				default:
+					slog.Warn("Too many keys pressed, discarding them", "callId", t.id)
+				}
+			}
+		case audiosocket.KindError:
+			slog.Warn("Packet loss when sending to audiosocket", "callId", t.id)
+		case audiosocket.KindSlin:
+			frame := m.Payload()
+			if t.audioFormat == "g711" {
+				frame = voice.DecodeG711(frame, t.g711AudioCodec)
+			}
+			return frame, nil
+		}
+	}
+}
+
+// Keys returns the keys pressed by the caller
+func (t *transport) Keys() <-chan byte {
+	return t.keys
+}
+
+func (t *transport) WriteAudio(frame []byte) error {
+	if _, err := t.conn.Write(audiosocket.SlinMessage(frame)); err != nil {
+		return errors.Wrap(err, "failed to write chunk to audiosocket")
+	}
+	return nil
+}
+
+// Hangup sends the hangup signal to Asterisk
+func (t *transport) Hangup() error {
+	_, err := t.conn.Write(audiosocket.HangupMessage())
+	return err
+}
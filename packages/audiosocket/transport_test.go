@@ -0,0 +1,105 @@
+package audiosocketserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/CyCoreSystems/audiosocket"
+	"github.com/felipem1210/freetalkbot/packages/voice"
+)
+
+// message encodes an AudioSocket message of the kind
+func message(kind audiosocket.Kind, payload []byte) []byte {
+	return append([]byte{byte(kind), byte(len(payload) >> 8), byte(len(payload))}, payload...)
+}
+
+// connect returns the transport of the call and the connection of Asterisk, which writes the messages
+func connect(t *testing.T, audioFormat string, messages ...[]byte) *transport {
+	conn, asterisk := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		defer asterisk.Close()
+		for _, m := range messages {
+			if _, err := asterisk.Write(m); err != nil {
+				return
+			}
+		}
+	}()
+	return newTransport(conn, audioFormat, "ulaw")
+}
+
+func TestReadAudioKeys(t *testing.T) {
+	audio := make([]byte, voice.FrameSize)
+	for i := range audio {
+		audio[i] = byte(i)
+	}
+	tr := connect(t, "pcm",
+		message(kindDtmf, []byte("5")),
+		message(kindDtmf, nil),
+		message(audiosocket.KindError, []byte{0x03}),
+		message(kindDtmf, []byte("#")),
+		message(audiosocket.KindSlin, audio),
+		message(kindDtmf, []byte("*")),
+		message(audiosocket.KindHangup, nil),
+	)
+
+	// The keys are received until the audio, the empty keys and the errors are skipped
+	frame, err := tr.ReadAudio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame) != string(audio) {
+		t.Errorf("got %d bytes of audio, want the audio of the message", len(frame))
+	}
+	if got := string(received(tr)); got != "5#" {
+		t.Errorf("got keys %q, want 5#", got)
+	}
+	if _, err := tr.ReadAudio(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v after the hangup, want EOF", err)
+	}
+	if got := string(received(tr)); got != "*" {
+		t.Errorf("got keys %q, want *", got)
+	}
+}
+
+func TestReadAudioTooManyKeys(t *testing.T) {
+	var messages [][]byte
+	for i := 0; i < 40; i++ {
+		messages = append(messages, message(kindDtmf, []byte{'0' + byte(i%10)}))
+	}
+	tr := connect(t, "pcm", messages...)
+	// The keys not taken are discarded, the call goes on
+	if _, err := tr.ReadAudio(); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v when the connection closed, want EOF", err)
+	}
+	if got := len(received(tr)); got != cap(tr.keys) {
+		t.Errorf("got %d keys, want %d", got, cap(tr.keys))
+	}
+}
+
+func TestReadAudioG711(t *testing.T) {
+	ulaw := []byte{0x00, 0x7F, 0x80, 0xFF}
+	tr := connect(t, "g711", message(audiosocket.KindSlin, ulaw))
+	frame, err := tr.ReadAudio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := voice.DecodeG711(ulaw, "ulaw"); string(frame) != string(want) {
+		t.Errorf("got %v, want the g711 audio decoded", frame)
+	}
+}
+
+// received returns the keys received so far
+func received(tr *transport) []byte {
+	var keys []byte
+	for {
+		select {
+		case key := <-tr.Keys():
+			keys = append(keys, key)
+		default:
+			return keys
+		}
+	}
+}
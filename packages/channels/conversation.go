@@ -0,0 +1,59 @@
+package channels
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// Conversation is the state of the chat with a user of a text channel
+type Conversation struct {
+	Language string
+	// Buttons are the options offered in the last responses, the next message of the user can choose one of them
+	Buttons common.Buttons
+}
+
+// DetectLanguage returns the language of the text, or the language of the conversation when it can't be detected,
+// e.g. in locations, files without caption or numbers
+func (c Conversation) DetectLanguage(text string) string {
+	language := common.DetectLanguage(text)
+	if language == "none" && c.Language != "" {
+		return c.Language
+	}
+	return language
+}
+
+// Ask sends the message of the user to the assistant as the next turn of the conversation. A text answering with the
+// number or the title of an option of the previous responses sends the option. The language is detected in the
+// message when it is empty. save is called to store the conversation before asking the assistant, so its language
+// is known when the responses are delivered later by a callback, and after with the options of the responses.
+func (c *Conversation) Ask(ctx context.Context, sender string, language string, message common.Message, save func()) (common.Responses, error) {
+	if language == "" {
+		language = c.DetectLanguage(message.Words())
+	}
+	slog.Debug(fmt.Sprintf("detected language: %s", language), "sender", sender)
+	if button, ok := c.Buttons.Match(message.Text); ok && message.Media == nil && message.Location == nil {
+		slog.Debug(fmt.Sprintf("user chose option: %s", button.Title), "sender", sender)
+		message.Text = button.Reply()
+	}
+	c.Language, c.Buttons = language, nil
+	save()
+
+	responses, err := assistants.HandleAssistant(ctx, language, sender, message)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving response from assistant %s: %w", os.Getenv("ASSISTANT_TOOL"), err)
+	}
+	slog.Debug(fmt.Sprintf("response from %v: %v", os.Getenv("ASSISTANT_TOOL"), responses), "sender", sender)
+
+	for _, r := range responses {
+		if len(r.Buttons) > 0 {
+			c.Buttons = r.Buttons
+		}
+	}
+	save()
+	return responses, nil
+}
@@ -0,0 +1,125 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// fakeAssistant answers with its responses and keeps the messages it received
+type fakeAssistant struct {
+	responses common.Responses
+	err       error
+	messages  []common.Message
+	languages []string
+}
+
+var assistant = &fakeAssistant{}
+
+func init() {
+	assistants.Register("channels-test", nil, func() (assistants.Assistant, error) { return assistant, nil })
+}
+
+func (a *fakeAssistant) Interact(ctx context.Context, sender string, language string, m common.Message) (common.Responses, error) {
+	a.messages = append(a.messages, m)
+	a.languages = append(a.languages, language)
+	return a.responses, a.err
+}
+
+func TestAsk(t *testing.T) {
+	t.Setenv("ASSISTANT_TOOL", "channels-test")
+	menu := common.Buttons{{Title: "Pay my bill", Payload: "/pay"}, {Title: "Talk to an agent", Payload: "/agent"}}
+	tests := []struct {
+		name        string
+		previous    Conversation
+		language    string
+		message     common.Message
+		responses   common.Responses
+		wantText    string
+		wantLang    string
+		wantButtons common.Buttons
+	}{
+		{
+			name:        "buttons offered are kept",
+			message:     common.TextMessage("Hello, I need help with my bill"),
+			responses:   common.Responses{{Text: "What do you want to do?", Buttons: menu}},
+			wantText:    "Hello, I need help with my bill",
+			wantLang:    "en",
+			wantButtons: menu,
+		},
+		{
+			name:      "option chosen by number keeps the language",
+			previous:  Conversation{Language: "es", Buttons: menu},
+			message:   common.TextMessage("2"),
+			responses: common.Responses{{Text: "Un agente te atenderá"}},
+			wantText:  "/agent",
+			wantLang:  "es",
+		},
+		{
+			name:     "option chosen by title",
+			previous: Conversation{Language: "en", Buttons: menu},
+			message:  common.TextMessage("pay my bill!"),
+			wantText: "/pay",
+			wantLang: "en",
+		},
+		{
+			name:     "media is never an option",
+			previous: Conversation{Language: "en", Buttons: menu},
+			message:  common.Message{Text: "1", Media: &common.Media{MimeType: "image/png"}},
+			wantText: "1",
+			wantLang: "en",
+		},
+		{
+			name:     "language set by the channel",
+			previous: Conversation{Language: "en"},
+			language: "pt",
+			message:  common.TextMessage("Hello"),
+			wantText: "Hello",
+			wantLang: "pt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*assistant = fakeAssistant{responses: tt.responses}
+			c := tt.previous
+			var saved []Conversation
+			responses, err := c.Ask(context.Background(), "ana", tt.language, tt.message, func() { saved = append(saved, c) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(responses) != len(tt.responses) {
+				t.Errorf("got %d responses, want %d", len(responses), len(tt.responses))
+			}
+			if got := assistant.messages[0].Text; got != tt.wantText {
+				t.Errorf("assistant got %q, want %q", got, tt.wantText)
+			}
+			if got := assistant.languages[0]; got != tt.wantLang || c.Language != tt.wantLang {
+				t.Errorf("language is %q, conversation %q, want %q", got, c.Language, tt.wantLang)
+			}
+			if len(c.Buttons) != len(tt.wantButtons) {
+				t.Errorf("conversation has buttons %v, want %v", c.Buttons, tt.wantButtons)
+			}
+			// Saved with the language before asking, and with the buttons after
+			if len(saved) != 2 || saved[0].Language != tt.wantLang || saved[0].Buttons != nil || len(saved[1].Buttons) != len(tt.wantButtons) {
+				t.Errorf("conversation saved as %+v", saved)
+			}
+		})
+	}
+}
+
+func TestAskError(t *testing.T) {
+	t.Setenv("ASSISTANT_TOOL", "channels-test")
+	*assistant = fakeAssistant{err: errors.New("unavailable")}
+	c := Conversation{Language: "en", Buttons: common.Buttons{{Title: "Yes"}}}
+	saves := 0
+	if _, err := c.Ask(context.Background(), "ana", "", common.TextMessage("yes"), func() { saves++ }); err == nil {
+		t.Fatal("expected the error of the assistant")
+	}
+	// The option was used, it can't be chosen again
+	if saves != 1 || c.Buttons != nil {
+		t.Errorf("saved %d times with buttons %v, want once without buttons", saves, c.Buttons)
+	}
+}
@@ -0,0 +1,77 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/felipem1210/freetalkbot/packages/stt"
+	"github.com/felipem1210/freetalkbot/packages/tts"
+)
+
+// Channel is a communication channel with the users, like phone calls or WhatsApp.
+// Several channels can run in the same process.
+type Channel interface {
+	// Name returns the name of the channel, the value used in the communication-channel flag
+	Name() string
+	// Start serves the users until ctx is done or the channel fails
+	Start(ctx context.Context) error
+	// Stop waits for the conversations in progress and releases the resources of the channel
+	Stop() error
+}
+
+// Engines are the STT and TTS engines shared by all the channels. TTS is nil when no channel needs it.
+type Engines struct {
+	STT stt.STT
+	TTS tts.TTS
+}
+
+// Run starts the channels and blocks until ctx is done or any of them fails, then all of them are stopped.
+// A channel which ends without error doesn't stop the others.
+func Run(ctx context.Context, chans ...Channel) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan error, len(chans))
+	var running sync.WaitGroup
+	running.Add(len(chans))
+	for _, c := range chans {
+		go func(c Channel) {
+			defer running.Done()
+			slog.Info(fmt.Sprintf("starting %s channel", c.Name()))
+			err := c.Start(ctx)
+			switch {
+			case err != nil:
+				err = fmt.Errorf("%s channel failed: %w", c.Name(), err)
+				slog.Error(err.Error())
+				// One channel failing stops the others
+				cancel()
+			case ctx.Err() == nil:
+				slog.Warn(fmt.Sprintf("%s channel ended, the other channels keep running", c.Name()))
+			}
+			results <- err
+		}(c)
+	}
+	// There is nothing left to run when all the channels ended
+	go func() {
+		running.Wait()
+		cancel()
+	}()
+
+	<-ctx.Done()
+	slog.Info("stopping channels")
+	var errs []error
+	for _, c := range chans {
+		if err := c.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s channel: %w", c.Name(), err))
+		}
+	}
+	for range chans {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
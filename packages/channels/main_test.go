@@ -0,0 +1,106 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeChannel serves until ctx is done, or ends after the delay with err
+type fakeChannel struct {
+	name    string
+	end     time.Duration
+	err     error
+	stopped atomic.Bool
+}
+
+func (c *fakeChannel) Name() string {
+	return c.name
+}
+
+func (c *fakeChannel) Start(ctx context.Context) error {
+	if c.end == 0 {
+		<-ctx.Done()
+		return nil
+	}
+	select {
+	case <-time.After(c.end):
+		return c.err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (c *fakeChannel) Stop() error {
+	c.stopped.Store(true)
+	return nil
+}
+
+// run runs the channels in the background, returning the channel where the result of Run is sent
+func run(ctx context.Context, chans ...Channel) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- Run(ctx, chans...) }()
+	return result
+}
+
+func TestRunStopsWhenAChannelFails(t *testing.T) {
+	failing := &fakeChannel{name: "failing", end: 10 * time.Millisecond, err: errors.New("port in use")}
+	serving := &fakeChannel{name: "serving"}
+
+	select {
+	case err := <-run(context.Background(), failing, serving):
+		if err == nil || !errors.Is(err, failing.err) {
+			t.Errorf("got error %v, want the error of the failing channel", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return when a channel failed")
+	}
+	if !serving.stopped.Load() || !failing.stopped.Load() {
+		t.Error("the channels were not stopped")
+	}
+}
+
+func TestRunKeepsRunningWhenAChannelEnds(t *testing.T) {
+	ended := &fakeChannel{name: "ended", end: 10 * time.Millisecond}
+	serving := &fakeChannel{name: "serving"}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := run(ctx, ended, serving)
+
+	select {
+	case err := <-result:
+		t.Fatalf("Run returned %v when a channel ended without error", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if serving.stopped.Load() {
+		t.Fatal("the serving channel was stopped")
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("got error %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return when ctx was done")
+	}
+	if !serving.stopped.Load() {
+		t.Error("the serving channel was not stopped")
+	}
+}
+
+func TestRunReturnsWhenAllChannelsEnd(t *testing.T) {
+	first := &fakeChannel{name: "first", end: 10 * time.Millisecond}
+	second := &fakeChannel{name: "second", end: 20 * time.Millisecond}
+
+	select {
+	case err := <-run(context.Background(), first, second):
+		if err != nil {
+			t.Errorf("got error %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return when all the channels ended")
+	}
+}
@@ -0,0 +1,113 @@
+package channels
+
+import (
+	"sync"
+	"time"
+)
+
+// ConversationTTL is the time after the last message when the state of a conversation is forgotten
+const ConversationTTL = 24 * time.Hour
+
+// StateStore keeps the state of the conversations of a channel by user, forgetting the ones that have been
+// inactive for longer than the TTL. It is safe for concurrent use.
+type StateStore[K comparable, V any] struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	states    map[K]state[V]
+	locks     map[K]*userLock
+	lastSweep time.Time
+}
+
+type state[V any] struct {
+	value   V
+	updated time.Time
+}
+
+// userLock serializes the turns of a user, it is removed when nobody holds or waits for it
+type userLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// NewStateStore creates a store forgetting the states after ttl
+func NewStateStore[K comparable, V any](ttl time.Duration) *StateStore[K, V] {
+	return &StateStore[K, V]{
+		ttl:       ttl,
+		states:    make(map[K]state[V]),
+		locks:     make(map[K]*userLock),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the state of the user, false when it is unknown or expired
+func (s *StateStore[K, V]) Get(key K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.states[key]
+	if !ok || s.expired(st, time.Now()) {
+		var zero V
+		return zero, false
+	}
+	return st.value, true
+}
+
+// Save stores the state of the user
+func (s *StateStore[K, V]) Save(key K, value V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.states[key] = state[V]{value: value, updated: now}
+	s.sweep(now)
+}
+
+// Update changes the state of the user, if it is known
+func (s *StateStore[K, V]) Update(key K, change func(*V)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.states[key]
+	if !ok || s.expired(st, time.Now()) {
+		return
+	}
+	change(&st.value)
+	s.states[key] = st
+}
+
+// Lock waits until the previous messages of the user are answered, the returned function unlocks the user
+func (s *StateStore[K, V]) Lock(key K) func() {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &userLock{}
+		s.locks[key] = l
+	}
+	l.users++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if l.users--; l.users == 0 {
+			delete(s.locks, key)
+		}
+	}
+}
+
+func (s *StateStore[K, V]) expired(st state[V], now time.Time) bool {
+	return s.ttl > 0 && now.Sub(st.updated) > s.ttl
+}
+
+// sweep removes the expired states. The whole store is checked at most once every tenth of the TTL, the expired
+// states not removed yet are never returned. It must be called holding mu.
+func (s *StateStore[K, V]) sweep(now time.Time) {
+	if s.ttl <= 0 || now.Sub(s.lastSweep) < s.ttl/10 {
+		return
+	}
+	s.lastSweep = now
+	for key, st := range s.states {
+		if s.expired(st, now) {
+			delete(s.states, key)
+		}
+	}
+}
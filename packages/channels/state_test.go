@@ -0,0 +1,111 @@
+package channels
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateStore(t *testing.T) {
+	s := NewStateStore[string, Conversation](time.Hour)
+	if _, ok := s.Get("ana"); ok {
+		t.Fatal("got the state of an unknown user")
+	}
+
+	s.Save("ana", Conversation{Language: "es"})
+	if c, ok := s.Get("ana"); !ok || c.Language != "es" {
+		t.Errorf("got %+v %v, want the saved state", c, ok)
+	}
+
+	s.Update("ana", func(c *Conversation) { c.Language = "pt" })
+	if c, _ := s.Get("ana"); c.Language != "pt" {
+		t.Errorf("got language %q after the update, want pt", c.Language)
+	}
+	// Unknown users are not created by an update
+	s.Update("bob", func(c *Conversation) { c.Language = "en" })
+	if _, ok := s.Get("bob"); ok {
+		t.Error("update created the state of an unknown user")
+	}
+}
+
+func TestStateStoreExpires(t *testing.T) {
+	s := NewStateStore[int64, Conversation](time.Hour)
+	s.Save(1, Conversation{Language: "es"})
+	s.Save(2, Conversation{Language: "en"})
+	// The first user has been inactive for longer than the TTL
+	expired := s.states[1]
+	expired.updated = time.Now().Add(-2 * time.Hour)
+	s.states[1] = expired
+
+	if _, ok := s.Get(1); ok {
+		t.Error("got an expired state")
+	}
+	s.Update(1, func(c *Conversation) { c.Language = "pt" })
+	if _, ok := s.Get(1); ok {
+		t.Error("update brought back an expired state")
+	}
+	if _, ok := s.states[1]; !ok {
+		t.Fatal("expired state removed before the sweep, the test is not valid")
+	}
+
+	// The store is swept once a tenth of the TTL went by since the last sweep
+	s.lastSweep = time.Now().Add(-time.Hour / 10)
+	s.Save(3, Conversation{})
+	if _, ok := s.states[1]; ok {
+		t.Error("expired state not removed by the sweep")
+	}
+	if _, ok := s.Get(2); !ok {
+		t.Error("active state removed by the sweep")
+	}
+}
+
+func TestStateStoreLock(t *testing.T) {
+	s := NewStateStore[string, Conversation](time.Hour)
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.Lock("ana")()
+			mu.Lock()
+			running++
+			max = maxInt(max, running)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+	// Other users are not blocked
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Lock("bob")()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a user was blocked by the lock of another one")
+	}
+
+	wg.Wait()
+	if max != 1 {
+		t.Errorf("%d turns of the same user ran at once", max)
+	}
+	if len(s.locks) != 0 {
+		t.Errorf("%d locks kept after all the turns ended", len(s.locks))
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,70 +1,171 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 
+	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/audiofork"
 	audiosocketserver "github.com/felipem1210/freetalkbot/packages/audiosocket"
+	"github.com/felipem1210/freetalkbot/packages/channels"
 	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/rest"
+	"github.com/felipem1210/freetalkbot/packages/stt"
+	"github.com/felipem1210/freetalkbot/packages/telegram"
+	"github.com/felipem1210/freetalkbot/packages/tts"
+	"github.com/felipem1210/freetalkbot/packages/twilio"
+	"github.com/felipem1210/freetalkbot/packages/webchat"
 	"github.com/felipem1210/freetalkbot/packages/whatsapp"
 	"github.com/spf13/cobra"
 )
 
+// channelNames are the values accepted by the communication-channel flag
+var channelNames = []string{"audio", "whatsapp", "webchat", "rest", "telegram", "twilio", "audiofork"}
+
 // prCmd represents the createPr command
 var prCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the bot.",
 	Long:  `Initialize the bot.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		comChan, _ := cmd.Flags().GetString("communication-channel")
+		comChans, _ := cmd.Flags().GetStringSlice("communication-channel")
 		common.SetLogger(os.Getenv("LOG_LEVEL"))
 		validateEnv([]string{"STT_TOOL", "ASSISTANT_TOOL"})
-		switch os.Getenv("STT_TOOL") {
-		case "whisper-local":
-			validateEnv([]string{"WHISPER_LOCAL_URL"})
-		case "whisper":
-			validateEnv([]string{"OPENAI_TOKEN"})
-		default:
-			fmt.Println("Invalid value for variable STT_TOOL, valid values are whisper-local and whisper")
+		sttEnv, err := stt.RequiredEnv(stt.Tool())
+		if err != nil {
+			fmt.Printf("Invalid value for variable STT_TOOL, valid values are %s\n", strings.Join(stt.Names(), ", "))
 			os.Exit(1)
 		}
+		validateEnv(sttEnv)
 
-		switch os.Getenv("ASSISTANT_TOOL") {
-		case "rasa":
-			validateEnv([]string{"RASA_URL", "ASSISTANT_LANGUAGE", "CALLBACK_SERVER_URL", "RASA_ACTIONS_SERVER_URL"})
-		case "anthropic":
-			validateEnv([]string{"ANTHROPIC_TOKEN", "ANTHROPIC_URL"})
+		assistantEnv, err := assistants.RequiredEnv(os.Getenv("ASSISTANT_TOOL"))
+		if err != nil {
+			fmt.Printf("Invalid value for variable ASSISTANT_TOOL, valid values are %s\n", strings.Join(assistants.Names(), ", "))
+			os.Exit(1)
+		}
+		validateEnv(assistantEnv)
+
+		switch os.Getenv("HISTORY_STORE") {
+		case "", "memory", "sqlite":
 		default:
-			fmt.Println("Invalid value for variable ASSISTANT_TOOL, valid values are rasa and anthropic")
+			fmt.Println("Invalid value for variable HISTORY_STORE, valid values are memory and sqlite")
 			os.Exit(1)
 		}
 
-		if comChan == "audio" {
-			validateEnv([]string{"AUDIO_FORMAT"})
-			switch os.Getenv("AUDIO_FORMAT") {
-			case "g711":
-				validateEnv([]string{"G711_AUDIO_CODEC"})
-			case "pcm16":
+		if len(comChans) == 0 {
+			fmt.Printf("missing communication channel, valid values are %s\n", strings.Join(channelNames, ", "))
+			os.Exit(1)
+		}
+		needsTts := false
+		for i, comChan := range comChans {
+			if slices.Contains(comChans[:i], comChan) {
+				fmt.Printf("Communication channel %s set twice\n", comChan)
+				os.Exit(1)
+			}
+			switch comChan {
+			case "audio":
+				validateEnv([]string{"AUDIO_FORMAT"})
+				switch os.Getenv("AUDIO_FORMAT") {
+				case "g711":
+					validateEnv([]string{"G711_AUDIO_CODEC"})
+				case "pcm16":
+				default:
+					fmt.Println("Invalid value for variable AUDIO_FORMAT, valid values are g711 and pcm16")
+					os.Exit(1)
+				}
+				needsTts = true
+			case "whatsapp":
+				validateEnv([]string{"SQL_DB_FILE_NAME"})
+				switch whatsapp.VoiceReplyMode() {
+				case whatsapp.VoiceReplyNever:
+				case whatsapp.VoiceReplyAlways, whatsapp.VoiceReplyMirror:
+					needsTts = true
+				default:
+					fmt.Println("Invalid value for variable WHATSAPP_VOICE_REPLY, valid values are always, never and mirror")
+					os.Exit(1)
+				}
+			case "webchat", "rest":
+			case "telegram":
+				validateEnv([]string{"TELEGRAM_BOT_TOKEN"})
+			case "twilio", "audiofork":
+				needsTts = true
 			default:
-				fmt.Println("Invalid value for variable AUDIO_FORMAT, valid values are g711 and pcm16")
+				fmt.Printf("Invalid communication channel %s, valid values are %s\n", comChan, strings.Join(channelNames, ", "))
 				os.Exit(1)
+			}
+		}
 
+		// The engines are shared by all the channels
+		engines := channels.Engines{}
+		engines.STT, err = stt.New(stt.Tool())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if needsTts {
+			engines.TTS = newTts()
+		}
+
+		chans := make([]channels.Channel, 0, len(comChans))
+		for _, comChan := range comChans {
+			var c channels.Channel
+			switch comChan {
+			case "audio":
+				c, err = audiosocketserver.New(engines)
+			case "whatsapp":
+				c, err = whatsapp.New(engines)
+			case "webchat":
+				c, err = webchat.New(engines)
+			case "rest":
+				c, err = rest.New(engines)
+			case "telegram":
+				c, err = telegram.New(engines)
+			case "twilio":
+				c, err = twilio.New(engines)
+			case "audiofork":
+				c, err = audiofork.New(engines)
 			}
-			if os.Getenv("AUDIO_FORMAT") == "g711" {
-				validateEnv([]string{"G711_AUDIO_CODEC"})
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to initialize %s channel: %v", comChan, err))
+				os.Exit(1)
 			}
-			audiosocketserver.InitializeServer()
-		} else if comChan == "whatsapp" {
-			validateEnv([]string{"SQL_DB_FILE_NAME"})
-			go whatsapp.InitializeCallbackServer()
-			whatsapp.InitializeServer()
+			chans = append(chans, c)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := channels.Run(ctx, chans...); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(prCmd)
-	prCmd.PersistentFlags().StringP("communication-channel", "c", "", "The communication channel to be used. Audio")
+	prCmd.PersistentFlags().StringSliceP("communication-channel", "c", nil, "The communication channels to be used, separated by commas. Options: "+strings.Join(channelNames, ", "))
+}
+
+// newTts validates the variables of the TTS tool and creates it
+func newTts() tts.TTS {
+	ttsEnv, err := tts.RequiredEnv(tts.Tool())
+	if err != nil {
+		fmt.Printf("Invalid value for variable TTS_TOOL, valid values are %s\n", strings.Join(tts.Names(), ", "))
+		os.Exit(1)
+	}
+	validateEnv(ttsEnv)
+	engine, err := tts.New(tts.Tool())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return engine
 }
 
 func validateEnv(envVars []string) {
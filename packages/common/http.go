@@ -2,32 +2,41 @@ package common
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 )
 
 type PostHttpReq struct {
-	Url           string
-	Headers       map[string]string
-	FormParams    map[string]string
-	JsonBody      map[string]string
+	Url        string
+	Headers    map[string]string
+	FormParams map[string]string
+	JsonBody   map[string]string
+	// TextBody is sent as the whole body with the "text" content type
+	TextBody      string
 	FileParamName string
 	FilePath      string
+	// FileData is sent as file, named FileName, instead of reading FilePath
+	FileData []byte
+	FileName string
 }
 
-type Response struct {
-	RecipientId string `json:"recipient_id"`
-	Text        string `json:"text"`
+func (r *PostHttpReq) SendPost(ct string) (io.ReadCloser, error) {
+	return r.SendPostWithContext(context.Background(), ct)
 }
 
-type Responses []Response
-
-func (r *PostHttpReq) SendPost(ct string) (io.ReadCloser, error) {
+// SendPostWithContext sends the POST request, aborting it when ctx is done
+func (r *PostHttpReq) SendPostWithContext(ctx context.Context, ct string) (io.ReadCloser, error) {
 	var requestBody bytes.Buffer
 	var ctContent string
 
@@ -42,8 +51,17 @@ func (r *PostHttpReq) SendPost(ct string) (io.ReadCloser, error) {
 				return nil, fmt.Errorf("error writing form field: %w", err)
 			}
 		}
-		// If a file path is provided, add the file to the form
-		if r.FilePath != "" && r.FileParamName != "" {
+		// If file data is provided, add it to the form as a file
+		if r.FileData != nil && r.FileParamName != "" {
+			part, err := writer.CreateFormFile(r.FileParamName, filepath.Base(r.FileName))
+			if err != nil {
+				return nil, fmt.Errorf("error creating form file: %w", err)
+			}
+			if _, err = part.Write(r.FileData); err != nil {
+				return nil, fmt.Errorf("error copying file content: %w", err)
+			}
+		} else if r.FilePath != "" && r.FileParamName != "" {
+			// If a file path is provided, add the file to the form
 			file, err := os.Open(r.FilePath)
 			if err != nil {
 				return nil, fmt.Errorf("error opening file: %w", err)
@@ -74,10 +92,14 @@ func (r *PostHttpReq) SendPost(ct string) (io.ReadCloser, error) {
 			return nil, fmt.Errorf("error converting data to JSON: %s", err)
 		}
 		ctContent = "application/json"
+
+	case "text":
+		requestBody.WriteString(r.TextBody)
+		ctContent = "text/plain; charset=utf-8"
 	}
 
 	// Create a POST request
-	req, err := http.NewRequest("POST", r.Url, &requestBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", r.Url, &requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
@@ -141,3 +163,95 @@ func (r Responses) ProcessJSONResponse(respBody io.ReadCloser) (Responses, error
 	}
 	return r, nil
 }
+
+// downloadClient only connects to public addresses. The files are chosen by the assistant, which can be told by
+// the user to point them to the loopback, private or link-local addresses of the server, e.g. to the metadata of
+// the cloud instance, and they are sent back to the user. The address is checked when dialing, after the name is
+// resolved and on every redirect, so it can't be bypassed with a name resolving to an internal address.
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, Control: dialPublic}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return checkDownloadUrl(req.URL)
+	},
+}
+
+// dialPublic fails when the address is not public
+func dialPublic(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("address %s is not public", addrPort.Addr())
+	}
+	return nil
+}
+
+// nonPublicPrefixes are the ranges of global unicast addresses which are not reachable from the internet:
+// "this network", which reaches the host itself, and the carrier-grade NAT of RFC 6598
+var nonPublicPrefixes = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/8"), netip.MustParsePrefix("100.64.0.0/10")}
+
+// isPublicAddr tells whether the address can be reached from the internet, it is not a loopback, private,
+// link-local, multicast or unspecified address
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDownloadUrl fails when the url is not http or https
+func checkDownloadUrl(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url scheme %q is not allowed", u.Scheme)
+	}
+	return nil
+}
+
+// DownloadFile gets the file in the url, failing when it is bigger than maxSize bytes. Only http and https
+// urls of public addresses are downloaded.
+// It returns the content and its mime type.
+func DownloadFile(ctx context.Context, fileUrl string, maxSize int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fileUrl, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating request: %w", err)
+	}
+	if err := checkDownloadUrl(req.URL); err != nil {
+		return nil, "", err
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, "", fmt.Errorf("error response from server: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading response body: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("file bigger than %d bytes", maxSize)
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	return data, mimeType, nil
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+func TestDownloadFileRejectsInternalUrls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("internal server reached at %s", r.URL)
+	}))
+	defer server.Close()
+
+	for _, fileUrl := range []string{
+		server.URL + "/secret.png",
+		strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/secret.png",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:80/",
+		"file:///etc/passwd",
+		"ftp://example.com/file.pdf",
+		"javascript:alert(1)",
+	} {
+		if _, _, err := DownloadFile(context.Background(), fileUrl, 1024); err == nil {
+			t.Errorf("%s downloaded", fileUrl)
+		}
+	}
+}
+
+func TestDownloadFileRedirects(t *testing.T) {
+	// The address of a redirect is checked when dialing, like the one of the first request, and its scheme here
+	for _, redirect := range []string{"file:///etc/passwd", "gopher://example.com/"} {
+		req, _ := http.NewRequest("GET", redirect, nil)
+		if err := downloadClient.CheckRedirect(req, nil); err == nil {
+			t.Errorf("redirect to %s allowed", redirect)
+		}
+	}
+	req, _ := http.NewRequest("GET", "https://example.com/image.png", nil)
+	if err := downloadClient.CheckRedirect(req, nil); err != nil {
+		t.Errorf("redirect to an https url rejected: %v", err)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(png)
+	}))
+	defer server.Close()
+	// The test server is local, which the download client refuses
+	defer func(client *http.Client) { downloadClient = client }(downloadClient)
+	downloadClient = server.Client()
+
+	data, mimeType, err := DownloadFile(context.Background(), server.URL+"/image", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(png) || mimeType != "image/png" {
+		t.Errorf("got %d bytes of %s, want the png", len(data), mimeType)
+	}
+	if _, _, err := DownloadFile(context.Background(), server.URL+"/image", 4); err == nil {
+		t.Error("file bigger than the maximum size downloaded")
+	}
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"strings"
 
+	gt "github.com/bas24/googletranslatefree"
 	lingua "github.com/pemistahl/lingua-go"
 )
 
@@ -26,3 +27,14 @@ func DetectLanguage(text string) string {
 		return "none"
 	}
 }
+
+// TranslateFromEnglish translates the text written by freetalkbot to the language of the user
+func TranslateFromEnglish(text string, language string) string {
+	if language == "" || language == "none" || language == "en" {
+		return text
+	}
+	if translated, err := gt.Translate(text, "en", language); err == nil && translated != "" {
+		return translated
+	}
+	return text
+}
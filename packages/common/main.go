@@ -1,7 +1,10 @@
 package common
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -9,22 +12,32 @@ import (
 )
 
 const (
-	DataDir      = "data/"
-	AudioDir     = DataDir + "audios/"
-	AudioEncPath = AudioDir + "audio.enc"
+	DataDir  = "data/"
+	AudioDir = DataDir + "audios/"
 )
 
-func ExecuteCommand(cmdString string) error {
-	cmd := exec.Command("/bin/sh", "-c", cmdString)
-	var out, stderr strings.Builder
+// ExecuteCommandContext runs the program with its arguments without a shell, so they are never interpreted.
+// stdin is written to the standard input of the program when not nil, and the program is killed when ctx is done.
+// It returns the standard output of the program.
+func ExecuteCommandContext(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var out bytes.Buffer
+	var stderr strings.Builder
+	cmd.Stdin = stdin
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s: %w", name, ctx.Err())
+	}
 	if err != nil {
-		return fmt.Errorf(stderr.String())
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
-	return nil
+	return out.Bytes(), nil
 }
 
 func SetLogger(ll string) {
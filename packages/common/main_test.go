@@ -0,0 +1,63 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteCommandContextDoesNotInterpretArguments(t *testing.T) {
+	pwned := filepath.Join(t.TempDir(), "pwned")
+	hostile := []string{
+		`"quoted" text"`,
+		"$(touch " + pwned + ")",
+		"`touch " + pwned + "`",
+		"a; touch " + pwned,
+		"a && touch " + pwned,
+		"-n",
+		"--version",
+		"line\n; touch " + pwned,
+	}
+	for _, text := range hostile {
+		out, err := ExecuteCommandContext(context.Background(), nil, "printf", "%s", text)
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if string(out) != text {
+			t.Errorf("argument %q arrived as %q", text, out)
+		}
+
+		out, err = ExecuteCommandContext(context.Background(), strings.NewReader(text), "cat")
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if string(out) != text {
+			t.Errorf("stdin %q arrived as %q", text, out)
+		}
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("an argument was executed")
+	}
+}
+
+func TestExecuteCommandContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := ExecuteCommandContext(ctx, nil, "sleep", "10"); err == nil {
+		t.Fatal("expected an error when ctx is done")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the command was not killed, it took %v", elapsed)
+	}
+}
+
+func TestExecuteCommandContextError(t *testing.T) {
+	_, err := ExecuteCommandContext(context.Background(), nil, "ls", "/does/not/exist")
+	if err == nil || !strings.HasPrefix(err.Error(), "ls: ") {
+		t.Errorf("got %v, want the error of ls", err)
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Message is a message received from a user, normalized from the format of each channel
+type Message struct {
+	// Text is the text written by the user, or the transcription of a voice message
+	Text string
+	// Caption is the text sent together with an image or a document
+	Caption  string
+	Media    *Media
+	Location *Location
+	// Digits are the keys pressed by the caller on the phone keypad
+	Digits string
+}
+
+// Media is a file sent by the user, like an image or a document
+type Media struct {
+	Data     []byte
+	MimeType string
+	FileName string
+}
+
+// Location is a location shared by the user
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Name      string
+	Address   string
+}
+
+// TextMessage creates a message with only text
+func TextMessage(text string) Message {
+	return Message{Text: text}
+}
+
+// IsImage tells if the media is an image
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.MimeType, "image/")
+}
+
+// IsText tells if the media is a document with plain text, like txt, csv or json files
+func (m Media) IsText() bool {
+	return strings.HasPrefix(m.MimeType, "text/") || m.MimeType == "application/json"
+}
+
+// Empty tells if the message has no content at all
+func (m Message) Empty() bool {
+	return m.Text == "" && m.Caption == "" && m.Media == nil && m.Location == nil && m.Digits == ""
+}
+
+// Words returns only the text written or said by the user, used to detect the language of the message
+func (m Message) Words() string {
+	return strings.TrimSpace(m.Text + "\n" + m.Caption)
+}
+
+// Content describes the whole message as text, for the assistants that only understand text
+func (m Message) Content() string {
+	var parts []string
+	if m.Text != "" {
+		parts = append(parts, m.Text)
+	}
+	if m.Caption != "" {
+		parts = append(parts, m.Caption)
+	}
+	if m.Media != nil {
+		parts = append(parts, m.Media.describe())
+	}
+	if m.Location != nil {
+		parts = append(parts, m.Location.describe())
+	}
+	if m.Digits != "" {
+		parts = append(parts, fmt.Sprintf("[Keys pressed on the phone keypad: %s]", m.Digits))
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (m Media) describe() string {
+	kind := "document"
+	if m.IsImage() {
+		kind = "image"
+	}
+	if m.FileName != "" {
+		return fmt.Sprintf("[Attached %s %q of type %s]", kind, m.FileName, m.MimeType)
+	}
+	return fmt.Sprintf("[Attached %s of type %s]", kind, m.MimeType)
+}
+
+func (l Location) describe() string {
+	place := strings.TrimSpace(strings.Join([]string{l.Name, l.Address}, " "))
+	if place != "" {
+		return fmt.Sprintf("[Shared location: %s (latitude %f, longitude %f)]", place, l.Latitude, l.Longitude)
+	}
+	return fmt.Sprintf("[Shared location: latitude %f, longitude %f]", l.Latitude, l.Longitude)
+}
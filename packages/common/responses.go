@@ -0,0 +1,130 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Response is a message of the assistant for a user. Besides the text it can carry buttons, an image,
+// an attachment or a custom payload, with the format of the REST channel of Rasa.
+type Response struct {
+	RecipientId string                 `json:"recipient_id"`
+	Text        string                 `json:"text"`
+	Buttons     Buttons                `json:"buttons,omitempty"`
+	Image       string                 `json:"image,omitempty"`
+	Attachment  *Attachment            `json:"attachment,omitempty"`
+	Custom      map[string]interface{} `json:"custom,omitempty"`
+}
+
+type Responses []Response
+
+// Button is an option offered to the user. Payload is sent to the assistant when the user chooses it.
+type Button struct {
+	Title   string `json:"title"`
+	Payload string `json:"payload"`
+}
+
+type Buttons []Button
+
+// Reply returns the message sent to the assistant when the user chooses the button
+func (b Button) Reply() string {
+	if b.Payload != "" {
+		return b.Payload
+	}
+	return b.Title
+}
+
+// Attachment is a file sent to the user. Type is image, video, audio or file.
+type Attachment struct {
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	FileName string `json:"file_name,omitempty"`
+}
+
+// UnmarshalJSON reads the attachment as it is sent by the actions of Rasa, an URL or an object
+// like {"type": "file", "payload": {"url": "...", "title": "..."}}
+func (a *Attachment) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*a = Attachment{Type: "file", URL: url}
+		return nil
+	}
+	var raw struct {
+		Type     string `json:"type"`
+		URL      string `json:"url"`
+		FileName string `json:"file_name"`
+		Payload  struct {
+			URL   string `json:"url"`
+			Src   string `json:"src"`
+			Title string `json:"title"`
+			Name  string `json:"name"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid attachment: %w", err)
+	}
+	*a = Attachment{Type: raw.Type, URL: raw.URL, FileName: raw.FileName}
+	if a.URL == "" {
+		a.URL = raw.Payload.URL
+	}
+	if a.URL == "" {
+		a.URL = raw.Payload.Src
+	}
+	if a.FileName == "" {
+		a.FileName = raw.Payload.Name
+	}
+	if a.FileName == "" {
+		a.FileName = raw.Payload.Title
+	}
+	if a.Type == "" {
+		a.Type = "file"
+	}
+	return nil
+}
+
+// Menu returns the text of the response followed by the numbered buttons, one per line,
+// for the channels that can't show buttons
+func (r Response) Menu() string {
+	lines := []string{}
+	if r.Text != "" {
+		lines = append(lines, r.Text)
+	}
+	for i, b := range r.Buttons {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, b.Title))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SpokenMenu returns the text of the response followed by the numbered buttons, to be read to the user
+func (r Response) SpokenMenu() string {
+	text := strings.TrimSpace(r.Text)
+	if len(r.Buttons) > 0 && text != "" && !strings.ContainsAny(text[len(text)-1:], ".?!") {
+		text += "."
+	}
+	for i, b := range r.Buttons {
+		text += fmt.Sprintf(" %d, %s.", i+1, strings.TrimRight(b.Title, ".?!"))
+	}
+	return strings.TrimSpace(text)
+}
+
+// Match returns the button chosen by the user in the reply, by its number or its title
+func (b Buttons) Match(reply string) (Button, bool) {
+	reply = strings.ToLower(strings.Trim(strings.TrimSpace(reply), ".,;:!?¿¡ "))
+	if reply == "" {
+		return Button{}, false
+	}
+	if n, err := strconv.Atoi(reply); err == nil {
+		if n >= 1 && n <= len(b) {
+			return b[n-1], true
+		}
+		return Button{}, false
+	}
+	for _, button := range b {
+		if strings.ToLower(strings.Trim(button.Title, ".,;:!?¿¡ ")) == reply {
+			return button, true
+		}
+	}
+	return Button{}, false
+}
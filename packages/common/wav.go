@@ -0,0 +1,101 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DecodeWav extracts the PCM 16bit samples and the sample rate from the content of a wav file.
+// Only the first channel is kept when the audio is not mono.
+func DecodeWav(data []byte) ([]byte, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("invalid wav header")
+	}
+
+	var sampleRate, channels, bitDepth int
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkId := string(data[pos : pos+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		switch chunkId {
+		case "fmt ":
+			if chunkSize < 16 || pos+16 > len(data) {
+				return nil, 0, fmt.Errorf("invalid wav fmt chunk")
+			}
+			if format := binary.LittleEndian.Uint16(data[pos : pos+2]); format != 1 {
+				return nil, 0, fmt.Errorf("unsupported wav format %d, only PCM is supported", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(data[pos+2 : pos+4]))
+			sampleRate = int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+			bitDepth = int(binary.LittleEndian.Uint16(data[pos+14 : pos+16]))
+		case "data":
+			if sampleRate == 0 {
+				return nil, 0, fmt.Errorf("wav data chunk found before fmt chunk")
+			}
+			if bitDepth != 16 {
+				return nil, 0, fmt.Errorf("unsupported wav bit depth %d, only 16 is supported", bitDepth)
+			}
+			// Streamed wav files don't know the size of the data, so it can be bigger than the file
+			end := pos + chunkSize
+			if chunkSize == 0 || end > len(data) || end < pos {
+				end = len(data)
+			}
+			return monoPCM16(data[pos:end], channels), sampleRate, nil
+		}
+		pos += chunkSize + chunkSize%2
+	}
+	return nil, 0, fmt.Errorf("wav data chunk not found")
+}
+
+// monoPCM16 keeps the first channel of interleaved PCM 16bit samples
+func monoPCM16(data []byte, channels int) []byte {
+	data = data[:len(data)-len(data)%2]
+	if channels <= 1 {
+		return data
+	}
+	frameSize := 2 * channels
+	mono := make([]byte, 0, len(data)/channels)
+	for i := 0; i+frameSize <= len(data); i += frameSize {
+		mono = append(mono, data[i], data[i+1])
+	}
+	return mono
+}
+
+// EncodeWav creates the content of a wav file with PCM 16bit signed linear mono (little-endian) samples
+func EncodeWav(pcm []byte, sampleRate int) []byte {
+	return encodeWav(pcm, sampleRate, 1)
+}
+
+// EncodeStereoWav creates the content of a stereo wav file with the PCM 16bit signed linear (little-endian)
+// samples of each channel. The shorter channel is padded with silence.
+func EncodeStereoWav(left []byte, right []byte, sampleRate int) []byte {
+	samples := max(len(left), len(right)) / 2
+	pcm := make([]byte, 4*samples)
+	for i := 0; i < samples; i++ {
+		if 2*i+2 <= len(left) {
+			copy(pcm[4*i:], left[2*i:2*i+2])
+		}
+		if 2*i+2 <= len(right) {
+			copy(pcm[4*i+2:], right[2*i:2*i+2])
+		}
+	}
+	return encodeWav(pcm, sampleRate, 2)
+}
+
+func encodeWav(pcm []byte, sampleRate int, channels int) []byte {
+	data := make([]byte, 44, 44+len(pcm))
+	copy(data[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(data[4:8], uint32(36+len(pcm)))
+	copy(data[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(data[16:20], 16)
+	binary.LittleEndian.PutUint16(data[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(data[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(data[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(data[28:32], uint32(sampleRate*2*channels))
+	binary.LittleEndian.PutUint16(data[32:34], uint16(2*channels))
+	binary.LittleEndian.PutUint16(data[34:36], 16)
+	copy(data[36:40], "data")
+	binary.LittleEndian.PutUint32(data[40:44], uint32(len(pcm)))
+	return append(data, pcm...)
+}
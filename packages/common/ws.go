@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 
@@ -16,23 +17,32 @@ type WsResponse struct {
 	Text string `json:"text"`
 }
 
-// SendWsMessage sends a message to the websocket server
-func (r *WsReq) SendWsMessage() (string, error) {
-	c, _, err := websocket.DefaultDialer.Dial(r.Url, nil)
+// SendWsMessage sends a message to the websocket server and returns the text of its answer.
+// The connection is closed when ctx is done.
+func (r *WsReq) SendWsMessage(ctx context.Context) (string, error) {
+	c, _, err := websocket.DefaultDialer.DialContext(ctx, r.Url, nil)
 	if err != nil {
 		return "", err
 	}
 	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
 
 	// Enviar un mensaje binario (por ejemplo, un timestamp convertido en bytes)
 	err = c.WriteMessage(websocket.BinaryMessage, r.Data)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", err
 	}
 
 	// Goroutine to receive messages from the server
 	_, message, err := c.ReadMessage()
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", err
 	}
 
@@ -0,0 +1,118 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+
+	defaultTTL        = 30 * time.Minute
+	defaultMaxTurns   = 20
+	defaultDbFileName = "history.db"
+)
+
+// Turn is a single message of a conversation
+type Turn struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+}
+
+// Store keeps the conversation of each sender (WhatsApp JID, call ID...) so assistants can have multi-turn context
+type Store interface {
+	// Get returns the turns of the conversation, oldest first, already truncated to the configured limits
+	Get(ctx context.Context, key string) ([]Turn, error)
+	// Append adds turns at the end of the conversation
+	Append(ctx context.Context, key string, turns ...Turn) error
+	// Clear removes the conversation
+	Clear(ctx context.Context, key string) error
+}
+
+// Limits bounds how much of a conversation is handed to an assistant
+type Limits struct {
+	// TTL is the time after the last turn when a conversation is forgotten. Zero means never.
+	TTL time.Duration
+	// MaxTurns is the maximum number of turns kept. Zero means unlimited.
+	MaxTurns int
+	// MaxTokens is the approximate token budget of the kept turns. Zero means unlimited.
+	MaxTokens int
+}
+
+// Truncate keeps the newest turns that fit in the limits, starting with a turn of the user so the pairs of
+// user and assistant turns are not split
+func (l Limits) Truncate(turns []Turn) []Turn {
+	start := 0
+	if l.MaxTurns > 0 && len(turns) > l.MaxTurns {
+		start = len(turns) - l.MaxTurns
+	}
+	if l.MaxTokens > 0 {
+		tokens := 0
+		for i := len(turns) - 1; i >= start; i-- {
+			tokens += EstimateTokens(turns[i].Content)
+			if tokens > l.MaxTokens {
+				start = i + 1
+				break
+			}
+		}
+	}
+	// The conversation must start with the user, some backends reject a history starting with the assistant
+	for start < len(turns) && turns[start].Role != RoleUser {
+		start++
+	}
+	return turns[start:]
+}
+
+// EstimateTokens approximates the number of tokens of a text, around 4 characters per token
+func EstimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+// LimitsFromEnv reads the limits from HISTORY_TTL, HISTORY_MAX_TURNS and HISTORY_MAX_TOKENS
+func LimitsFromEnv() (Limits, error) {
+	limits := Limits{TTL: defaultTTL, MaxTurns: defaultMaxTurns}
+	var err error
+	if v := os.Getenv("HISTORY_TTL"); v != "" {
+		if limits.TTL, err = time.ParseDuration(v); err != nil {
+			return limits, fmt.Errorf("invalid value for HISTORY_TTL: %s", err)
+		}
+	}
+	if v := os.Getenv("HISTORY_MAX_TURNS"); v != "" {
+		if limits.MaxTurns, err = strconv.Atoi(v); err != nil {
+			return limits, fmt.Errorf("invalid value for HISTORY_MAX_TURNS: %s", err)
+		}
+	}
+	if v := os.Getenv("HISTORY_MAX_TOKENS"); v != "" {
+		if limits.MaxTokens, err = strconv.Atoi(v); err != nil {
+			return limits, fmt.Errorf("invalid value for HISTORY_MAX_TOKENS: %s", err)
+		}
+	}
+	return limits, nil
+}
+
+// NewFromEnv creates the store configured in HISTORY_STORE, memory by default
+func NewFromEnv() (Store, error) {
+	limits, err := LimitsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	switch os.Getenv("HISTORY_STORE") {
+	case "", "memory":
+		return NewMemoryStore(limits), nil
+	case "sqlite":
+		dbFileName := os.Getenv("HISTORY_SQL_DB_FILE_NAME")
+		if dbFileName == "" {
+			dbFileName = defaultDbFileName
+		}
+		return NewSQLiteStore(common.DataDir+dbFileName, limits)
+	default:
+		return nil, fmt.Errorf("invalid value for HISTORY_STORE: %s", os.Getenv("HISTORY_STORE"))
+	}
+}
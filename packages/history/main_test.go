@@ -0,0 +1,80 @@
+package history
+
+import (
+	"strings"
+	"testing"
+)
+
+func alternating(contents ...string) []Turn {
+	turns := make([]Turn, len(contents))
+	for i, c := range contents {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		turns[i] = Turn{Role: role, Content: c}
+	}
+	return turns
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("x", 40) // 10 tokens
+	tests := []struct {
+		name   string
+		limits Limits
+		turns  []Turn
+		want   []string
+	}{
+		{
+			name:  "no limits",
+			turns: alternating("u1", "a1", "u2", "a2"),
+			want:  []string{"u1", "a1", "u2", "a2"},
+		},
+		{
+			name:   "max turns keeps whole pairs",
+			limits: Limits{MaxTurns: 3},
+			turns:  alternating("u1", "a1", "u2", "a2"),
+			want:   []string{"u2", "a2"},
+		},
+		{
+			name:   "max turns even",
+			limits: Limits{MaxTurns: 2},
+			turns:  alternating("u1", "a1", "u2", "a2"),
+			want:   []string{"u2", "a2"},
+		},
+		{
+			name:   "max tokens keeps whole pairs",
+			limits: Limits{MaxTokens: 25},
+			turns:  alternating(long, long, long, long),
+			want:   []string{long, long},
+		},
+		{
+			name:   "max tokens drops everything",
+			limits: Limits{MaxTokens: 5},
+			turns:  alternating(long, long),
+			want:   []string{},
+		},
+		{
+			name:   "history starting with the assistant",
+			limits: Limits{MaxTurns: 10},
+			turns:  append([]Turn{{Role: RoleAssistant, Content: "greeting"}}, alternating("u1", "a1")...),
+			want:   []string{"u1", "a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.limits.Truncate(tt.turns)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d turns, want %d", len(got), len(tt.want))
+			}
+			for i, turn := range got {
+				if turn.Content != tt.want[i] {
+					t.Errorf("turn %d is %q, want %q", i, turn.Content, tt.want[i])
+				}
+			}
+			if len(got) > 0 && got[0].Role != RoleUser {
+				t.Errorf("history starts with %s", got[0].Role)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type conversation struct {
+	turns   []Turn
+	updated time.Time
+}
+
+// MemoryStore keeps the conversations in memory, they are lost when the process restarts
+type MemoryStore struct {
+	mu            sync.Mutex
+	limits        Limits
+	conversations map[string]*conversation
+}
+
+func NewMemoryStore(limits Limits) *MemoryStore {
+	return &MemoryStore{
+		limits:        limits,
+		conversations: make(map[string]*conversation),
+	}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]Turn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conversations[key]
+	if !ok {
+		return nil, nil
+	}
+	if m.expired(c, time.Now()) {
+		delete(m.conversations, key)
+		return nil, nil
+	}
+	turns := m.limits.Truncate(c.turns)
+	return append([]Turn(nil), turns...), nil
+}
+
+func (m *MemoryStore) Append(ctx context.Context, key string, turns ...Turn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.removeExpired(now)
+	c, ok := m.conversations[key]
+	if !ok {
+		c = &conversation{}
+		m.conversations[key] = c
+	}
+	for _, t := range turns {
+		if t.Time.IsZero() {
+			t.Time = now
+		}
+		c.turns = append(c.turns, t)
+	}
+	// Only the turns within the limits will ever be read, drop the rest
+	c.turns = append([]Turn(nil), m.limits.Truncate(c.turns)...)
+	c.updated = now
+	return nil
+}
+
+func (m *MemoryStore) Clear(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conversations, key)
+	return nil
+}
+
+func (m *MemoryStore) expired(c *conversation, now time.Time) bool {
+	return m.limits.TTL > 0 && now.Sub(c.updated) > m.limits.TTL
+}
+
+// removeExpired deletes the conversations whose TTL is over
+func (m *MemoryStore) removeExpired(now time.Time) {
+	for key, c := range m.conversations {
+		if m.expired(c, now) {
+			delete(m.conversations, key)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createTableQuery = `CREATE TABLE IF NOT EXISTS conversation_turns (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	conversation_key TEXT NOT NULL,
+	role TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS conversation_turns_key ON conversation_turns (conversation_key, id);`
+
+// SQLiteStore keeps the conversations in a SQLite database, so they survive a restart of the process
+type SQLiteStore struct {
+	db     *sql.DB
+	limits Limits
+}
+
+func NewSQLiteStore(dbFilePath string, limits Limits) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+dbFilePath+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	if _, err := db.Exec(createTableQuery); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create history table: %w", err)
+	}
+	return &SQLiteStore{db: db, limits: limits}, nil
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, key string) ([]Turn, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT role, content, created_at FROM conversation_turns WHERE conversation_key = ? ORDER BY id", key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	var turns []Turn
+	for rows.Next() {
+		var t Turn
+		var createdAt int64
+		if err := rows.Scan(&t.Role, &t.Content, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		t.Time = time.Unix(0, createdAt)
+		turns = append(turns, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	// The TTL is counted from the last turn, so a stale conversation is forgotten as a whole
+	if len(turns) > 0 && s.limits.TTL > 0 && time.Since(turns[len(turns)-1].Time) > s.limits.TTL {
+		return nil, nil
+	}
+	return s.limits.Truncate(turns), nil
+}
+
+func (s *SQLiteStore) Append(ctx context.Context, key string, turns ...Turn) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin history transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, t := range turns {
+		if t.Time.IsZero() {
+			t.Time = now
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO conversation_turns (conversation_key, role, content, created_at) VALUES (?, ?, ?, ?)",
+			key, t.Role, t.Content, t.Time.UnixNano()); err != nil {
+			return fmt.Errorf("failed to store history: %w", err)
+		}
+	}
+	if s.limits.TTL > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM conversation_turns WHERE conversation_key IN
+			(SELECT conversation_key FROM conversation_turns GROUP BY conversation_key HAVING MAX(created_at) < ?)`,
+			now.Add(-s.limits.TTL).UnixNano()); err != nil {
+			return fmt.Errorf("failed to remove expired history: %w", err)
+		}
+	}
+	if s.limits.MaxTurns > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM conversation_turns WHERE conversation_key = ? AND id NOT IN
+			(SELECT id FROM conversation_turns WHERE conversation_key = ? ORDER BY id DESC LIMIT ?)`,
+			key, key, s.limits.MaxTurns); err != nil {
+			return fmt.Errorf("failed to truncate history: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Clear(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM conversation_turns WHERE conversation_key = ?", key); err != nil {
+		return fmt.Errorf("failed to clear history: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,140 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stores returns a constructor of each store, the SQLite ones sharing a database in the test directory
+func stores(t *testing.T) map[string]func(Limits) Store {
+	dir := t.TempDir()
+	return map[string]func(Limits) Store{
+		"memory": func(l Limits) Store { return NewMemoryStore(l) },
+		"sqlite": func(l Limits) Store {
+			s, err := NewSQLiteStore(filepath.Join(dir, "history.db"), l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
+// contents returns the contents of the turns of the conversation
+func contents(t *testing.T, s Store, key string) []string {
+	t.Helper()
+	turns, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, turn := range turns {
+		got = append(got, turn.Content)
+	}
+	return got
+}
+
+func expectContents(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got turns %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got turns %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStoreAppend(t *testing.T) {
+	ctx := context.Background()
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(Limits{MaxTurns: 4})
+			expectContents(t, contents(t, s, "ana"))
+
+			if err := s.Append(ctx, "ana", alternating("u1", "a1")...); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Append(ctx, "bob", alternating("hi bob")...); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Append(ctx, "ana", alternating("u2", "a2")...); err != nil {
+				t.Fatal(err)
+			}
+			expectContents(t, contents(t, s, "ana"), "u1", "a1", "u2", "a2")
+			expectContents(t, contents(t, s, "bob"), "hi bob")
+
+			// Only the newest turns within the limits are kept
+			if err := s.Append(ctx, "ana", alternating("u3", "a3")...); err != nil {
+				t.Fatal(err)
+			}
+			expectContents(t, contents(t, s, "ana"), "u2", "a2", "u3", "a3")
+
+			if err := s.Clear(ctx, "ana"); err != nil {
+				t.Fatal(err)
+			}
+			expectContents(t, contents(t, s, "ana"))
+			expectContents(t, contents(t, s, "bob"), "hi bob")
+		})
+	}
+}
+
+func TestStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(Limits{TTL: 100 * time.Millisecond})
+			if err := s.Append(ctx, "ana", alternating("u1", "a1")...); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(60 * time.Millisecond)
+			// A new turn keeps the whole conversation alive
+			if err := s.Append(ctx, "ana", alternating("u2")...); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(60 * time.Millisecond)
+			expectContents(t, contents(t, s, "ana"), "u1", "a1", "u2")
+
+			time.Sleep(100 * time.Millisecond)
+			expectContents(t, contents(t, s, "ana"))
+		})
+	}
+}
+
+func TestSQLiteStoreSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := NewSQLiteStore(path, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(ctx, "ana", alternating("u1", "a1", "u2", "a2")...); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewSQLiteStore(path, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	turns, err := s.Get(ctx, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContents(t, contents(t, s, "ana"), "u1", "a1", "u2", "a2")
+	for i, turn := range turns {
+		if want := alternating("u1", "a1")[i%2].Role; turn.Role != want {
+			t.Errorf("turn %d has role %s, want %s", i, turn.Role, want)
+		}
+		if turn.Time.IsZero() || time.Since(turn.Time) > time.Minute {
+			t.Errorf("turn %d has time %v", i, turn.Time)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/stt"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAddr = ":8095"
+	// maxRequestSize is the maximum size in bytes of a request, the audio included
+	maxRequestSize = 20 * 1024 * 1024
+	// webhookAttempts is the number of times the delivery to the webhook is tried
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+var sttEngine stt.STT
+
+// webhookClient doesn't follow redirects, so the posts never leave the allowed hosts
+var webhookClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// messageRequest is the body of POST /v1/messages. It is sent as JSON, or as a multipart form with the audio
+// in the file field audio.
+type messageRequest struct {
+	Sender     string `json:"sender" form:"sender"`
+	Text       string `json:"text" form:"text"`
+	Language   string `json:"language" form:"language"`
+	WebhookUrl string `json:"webhook_url" form:"webhook_url"`
+}
+
+// delivery is posted to the webhook of the request when the responses are delivered asynchronously
+type delivery struct {
+	Sender        string           `json:"sender"`
+	Language      string           `json:"language,omitempty"`
+	Transcription string           `json:"transcription,omitempty"`
+	Responses     common.Responses `json:"responses"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// Server is the rest channel. Other systems send the messages of their users to the API and get the responses
+// of the assistant in the reply, or in their webhook.
+type Server struct {
+	http         *http.Server
+	token        string
+	webhookHosts []string
+	senders      *channels.StateStore[string, channels.Conversation]
+	// ctx is the context of the channel, used by the deliveries to webhooks which outlive their requests
+	ctx        context.Context
+	deliveries sync.WaitGroup
+}
+
+// New creates the rest channel with the shared engines
+func New(engines channels.Engines) (*Server, error) {
+	sttEngine = engines.STT
+	addr := os.Getenv("REST_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	srv := &Server{token: os.Getenv("REST_API_TOKEN"), senders: channels.NewStateStore[string, channels.Conversation](channels.ConversationTTL), ctx: context.Background()}
+	if srv.token == "" {
+		if os.Getenv("REST_INSECURE") != "true" {
+			return nil, fmt.Errorf("REST_API_TOKEN must be set to use the rest channel, or REST_INSECURE=true to serve the API without authentication")
+		}
+		slog.Warn("REST_INSECURE is true, the rest API is served without authentication")
+	}
+	if hosts := os.Getenv("REST_WEBHOOK_ALLOWED_HOSTS"); hosts != "" {
+		for _, host := range strings.Split(hosts, ",") {
+			srv.webhookHosts = append(srv.webhookHosts, strings.TrimSpace(host))
+		}
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.Default()
+	router.POST("/v1/messages", srv.handleMessages)
+	srv.http = &http.Server{Addr: addr, Handler: router}
+	return srv, nil
+}
+
+func (srv *Server) Name() string {
+	return "rest"
+}
+
+// Start serves the API until ctx is done
+func (srv *Server) Start(ctx context.Context) error {
+	srv.ctx = ctx
+	go func() {
+		<-ctx.Done()
+		srv.shutdown()
+	}()
+
+	slog.Info(fmt.Sprintf("Starting rest server on %s", srv.http.Addr))
+	if err := srv.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop shuts down the server and waits until the pending deliveries to webhooks end
+func (srv *Server) Stop() error {
+	err := srv.shutdown()
+	srv.deliveries.Wait()
+	return err
+}
+
+func (srv *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.http.Shutdown(ctx)
+}
+
+// authorized checks the bearer token of the request, any request is authorized when REST_INSECURE is true
+func (srv *Server) authorized(c *gin.Context) bool {
+	if srv.token == "" {
+		return true
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1
+}
+
+// handleMessages runs the message through the transcription, language detection and assistant. The responses
+// are returned in the reply, or posted to webhook_url when it is set.
+func (srv *Server) handleMessages(c *gin.Context) {
+	if !srv.authorized(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestSize)
+
+	var req messageRequest
+	var audio stt.Audio
+	if c.ContentType() == "multipart/form-data" {
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid form: %s", err)})
+			return
+		}
+		if file, header, err := c.Request.FormFile("audio"); err == nil {
+			audio.Data, err = io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid audio: %s", err)})
+				return
+			}
+			audio.FileName = header.Filename
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if req.Sender == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sender is mandatory"})
+		return
+	}
+	if req.Text == "" && audio.Data == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text or audio is mandatory"})
+		return
+	}
+	if req.WebhookUrl != "" {
+		if err := srv.validateWebhook(req.WebhookUrl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		srv.deliveries.Add(1)
+		go func() {
+			defer srv.deliveries.Done()
+			d, _ := srv.process(srv.ctx, req, audio)
+			srv.deliver(srv.ctx, req.WebhookUrl, d)
+		}()
+		c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
+		return
+	}
+
+	d, status := srv.process(c.Request.Context(), req, audio)
+	if d.Error != "" {
+		c.JSON(status, gin.H{"error": d.Error})
+		return
+	}
+	c.JSON(status, d.Responses)
+}
+
+// validateWebhook checks that the webhook is an http URL of the hosts in REST_WEBHOOK_ALLOWED_HOSTS. Without it
+// no webhook is allowed, otherwise any client of the API could make the server post to internal addresses.
+func (srv *Server) validateWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook_url")
+	}
+	if srv.webhookHosts == nil {
+		return fmt.Errorf("webhook_url is not allowed, REST_WEBHOOK_ALLOWED_HOSTS is not set")
+	}
+	if !slices.Contains(srv.webhookHosts, u.Hostname()) {
+		return fmt.Errorf("webhook_url host %s is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// process sends the message to the assistant, returning the responses and the HTTP status of the result
+func (srv *Server) process(ctx context.Context, req messageRequest, audio stt.Audio) (delivery, int) {
+	d := delivery{Sender: req.Sender}
+	// The senders of the API are apart from the users of the other channels, which share the assistant
+	sender := "rest-" + req.Sender
+	defer srv.senders.Lock(sender)()
+	current, _ := srv.senders.Get(sender)
+
+	text := req.Text
+	if audio.Data != nil {
+		slog.Info("Received audio message", "sender", req.Sender)
+		transcription, err := sttEngine.Transcribe(ctx, audio, req.Language)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error transcribing audio message: %s", err), "sender", req.Sender)
+			d.Error = "The audio could not be transcribed"
+			return d, http.StatusUnprocessableEntity
+		}
+		d.Transcription = transcription
+		text = strings.TrimSpace(text + "\n" + transcription)
+	} else {
+		slog.Info("Received text message", "sender", req.Sender)
+	}
+	slog.Debug(fmt.Sprintf("message received: %s", text), "sender", req.Sender)
+
+	responses, err := current.Ask(ctx, sender, req.Language, common.TextMessage(text), func() { srv.senders.Save(sender, current) })
+	d.Language = current.Language
+	if err != nil {
+		slog.Error(err.Error(), "sender", req.Sender)
+		d.Error = "The assistant is not available"
+		return d, http.StatusBadGateway
+	}
+	if responses == nil {
+		responses = common.Responses{}
+	}
+	for i := range responses {
+		responses[i].RecipientId = req.Sender
+	}
+	d.Responses = responses
+	return d, http.StatusOK
+}
+
+// deliver posts the result to the webhook, retrying when it fails. When REST_API_TOKEN is set the body is signed
+// with it, in the header X-Freetalkbot-Signature.
+func (srv *Server) deliver(ctx context.Context, webhook string, d delivery) {
+	if d.Responses == nil {
+		d.Responses = common.Responses{}
+	}
+	body, err := json.Marshal(d)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error converting delivery to JSON: %s", err), "sender", d.Sender)
+		return
+	}
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err = srv.post(ctx, webhook, body)
+		if err == nil {
+			slog.Info(fmt.Sprintf("Responses delivered to %s", webhook), "sender", d.Sender)
+			return
+		}
+		slog.Warn(fmt.Sprintf("Error delivering responses to %s, attempt %d: %s", webhook, attempt, err), "sender", d.Sender)
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+	slog.Error(fmt.Sprintf("Responses not delivered to %s", webhook), "sender", d.Sender)
+}
+
+func (srv *Server) post(ctx context.Context, webhook string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if srv.token != "" {
+		mac := hmac.New(sha256.New, []byte(srv.token))
+		mac.Write(body)
+		req.Header.Set("X-Freetalkbot-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error response from webhook: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// fakeAssistant echoes the messages, keeping the senders it received
+type fakeAssistant struct {
+	mu      sync.Mutex
+	senders []string
+}
+
+var assistant = &fakeAssistant{}
+
+func init() {
+	assistants.Register("rest-test", nil, func() (assistants.Assistant, error) { return assistant, nil })
+}
+
+func (a *fakeAssistant) Interact(ctx context.Context, sender string, language string, m common.Message) (common.Responses, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.senders = append(a.senders, sender)
+	return common.Responses{{RecipientId: sender, Text: "echo: " + m.Text}}, nil
+}
+
+func newTestServer(t *testing.T, env map[string]string) *Server {
+	t.Setenv("ASSISTANT_TOOL", "rest-test")
+	for _, name := range []string{"REST_API_TOKEN", "REST_INSECURE", "REST_WEBHOOK_ALLOWED_HOSTS"} {
+		t.Setenv(name, env[name])
+	}
+	srv, err := New(channels.Engines{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// post sends the JSON body to the API with the token, returning the status and the body of the reply
+func post(srv *Server, token string, body string) (int, string) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	srv.http.Handler.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestNewNeedsToken(t *testing.T) {
+	t.Setenv("REST_API_TOKEN", "")
+	t.Setenv("REST_INSECURE", "")
+	if _, err := New(channels.Engines{}); err == nil {
+		t.Fatal("the channel started without token")
+	}
+
+	srv := newTestServer(t, map[string]string{"REST_INSECURE": "true"})
+	if status, body := post(srv, "", `{"sender": "user-1", "text": "hi"}`); status != http.StatusOK {
+		t.Errorf("got %d %s with REST_INSECURE=true, want 200", status, body)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	srv := newTestServer(t, map[string]string{"REST_API_TOKEN": "secret"})
+	for _, token := range []string{"", "wrong", "secret2"} {
+		if status, _ := post(srv, token, `{"sender": "user-1", "text": "hi"}`); status != http.StatusUnauthorized {
+			t.Errorf("got %d with token %q, want 401", status, token)
+		}
+	}
+	if status, body := post(srv, "secret", `{"sender": "user-1", "text": "hi"}`); status != http.StatusOK {
+		t.Errorf("got %d %s with the token, want 200", status, body)
+	}
+}
+
+func TestSendersAreNamespaced(t *testing.T) {
+	srv := newTestServer(t, map[string]string{"REST_API_TOKEN": "secret"})
+	// A client of the API can't talk as the user of another channel
+	status, body := post(srv, "secret", `{"sender": "34600000000@s.whatsapp.net", "text": "hi"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", status, body)
+	}
+
+	assistant.mu.Lock()
+	sender := assistant.senders[len(assistant.senders)-1]
+	assistant.mu.Unlock()
+	if sender != "rest-34600000000@s.whatsapp.net" {
+		t.Errorf("assistant got sender %q, want it namespaced", sender)
+	}
+	var responses common.Responses
+	if err := json.Unmarshal([]byte(body), &responses); err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0].RecipientId != "34600000000@s.whatsapp.net" {
+		t.Errorf("got responses %+v, want them for the sender of the request", responses)
+	}
+	if _, ok := srv.senders.Get("34600000000@s.whatsapp.net"); ok {
+		t.Error("state stored under the sender of the request")
+	}
+	if _, ok := srv.senders.Get("rest-34600000000@s.whatsapp.net"); !ok {
+		t.Error("state not stored under the namespaced sender")
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	delivered := make(chan delivery, 1)
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("redirect to %s followed", r.URL)
+	}))
+	defer internal.Close()
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+			return
+		}
+		var d delivery
+		json.NewDecoder(r.Body).Decode(&d)
+		if !strings.HasPrefix(r.Header.Get("X-Freetalkbot-Signature"), "sha256=") {
+			t.Error("delivery not signed")
+		}
+		delivered <- d
+	}))
+	defer webhook.Close()
+	webhookUrl, _ := url.Parse(webhook.URL)
+
+	tests := []struct {
+		name       string
+		allowed    string
+		webhook    string
+		wantStatus int
+	}{
+		{name: "no allowed hosts", webhook: webhook.URL, wantStatus: http.StatusBadRequest},
+		{name: "host not allowed", allowed: "crm.example.com", webhook: webhook.URL, wantStatus: http.StatusBadRequest},
+		{name: "link-local host", allowed: webhookUrl.Hostname(), webhook: "http://169.254.169.254/latest/meta-data", wantStatus: http.StatusBadRequest},
+		{name: "not http", allowed: webhookUrl.Hostname(), webhook: "file:///etc/passwd", wantStatus: http.StatusBadRequest},
+		{name: "allowed host", allowed: webhookUrl.Hostname(), webhook: webhook.URL + "/hook", wantStatus: http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, map[string]string{"REST_API_TOKEN": "secret", "REST_WEBHOOK_ALLOWED_HOSTS": tt.allowed})
+			body, _ := json.Marshal(messageRequest{Sender: "user-1", Text: "hi", WebhookUrl: tt.webhook})
+			if status, reply := post(srv, "secret", string(body)); status != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d", status, reply, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+			select {
+			case d := <-delivered:
+				if d.Sender != "user-1" || len(d.Responses) != 1 || d.Responses[0].RecipientId != "user-1" {
+					t.Errorf("unexpected delivery %+v", d)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("responses not delivered")
+			}
+			srv.Stop()
+		})
+	}
+
+	// A redirect of an allowed host is not followed
+	srv := newTestServer(t, map[string]string{"REST_API_TOKEN": "secret"})
+	if err := srv.post(context.Background(), webhook.URL+"/redirect", []byte("{}")); err == nil {
+		t.Error("redirect accepted as a delivery")
+	}
+}
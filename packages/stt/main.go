@@ -0,0 +1,143 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// Audio is the audio to transcribe: PCM samples, the content of an audio file or a file in disk
+type Audio struct {
+	// PCM are PCM 16bit signed linear mono (little-endian) samples
+	PCM        []byte
+	SampleRate int
+	// Data is the content of an audio file in any format, used when PCM is empty.
+	// The extension of FileName tells its format, e.g. voice.ogg
+	Data     []byte
+	FileName string
+	// FilePath is an audio file in any format, used when PCM and Data are empty
+	FilePath string
+}
+
+// STT is implemented by every backend able to transcribe speech
+type STT interface {
+	// Transcribe returns the text spoken in the audio. language is an ISO 639-1 hint, empty when it is not known.
+	Transcribe(ctx context.Context, audio Audio, language string) (string, error)
+}
+
+// Streamer is implemented by the backends able to transcribe the audio while it is being spoken
+type Streamer interface {
+	NewStream(ctx context.Context, sampleRate int, language string) (Stream, error)
+}
+
+// Stream is a streaming transcription session, opened once per call
+type Stream interface {
+	// Write sends a frame of PCM 16bit signed linear mono (little-endian) audio
+	Write(frame []byte) error
+	// Transcripts returns the channel where partial and final transcripts are sent, closed when the session ends
+	Transcripts() <-chan Transcript
+	// Commit closes the current utterance and returns its final transcript
+	Commit(ctx context.Context) (Transcript, error)
+	Close() error
+}
+
+// Transcript is a transcription received from a streaming session.
+// Partial transcripts can still change, the final one closes the utterance.
+type Transcript struct {
+	Text  string
+	Final bool
+}
+
+// Factory creates a new instance of a STT backend
+type Factory func() (STT, error)
+
+type registration struct {
+	factory     Factory
+	requiredEnv []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]registration{}
+)
+
+// Register makes a STT backend available under the given name, which is the value used in STT_TOOL.
+// requiredEnv are the env vars that must be set to use the backend.
+func Register(name string, requiredEnv []string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("stt: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("stt: Register called twice for backend " + name)
+	}
+	registry[name] = registration{factory: factory, requiredEnv: requiredEnv}
+}
+
+// Names returns the sorted list of the registered STT backends
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RequiredEnv returns the env vars needed by the backend registered with the given name
+func RequiredEnv(name string) ([]string, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	reg, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown stt backend %q", name)
+	}
+	return reg.requiredEnv, nil
+}
+
+// New creates the STT backend registered with the given name
+func New(name string) (STT, error) {
+	registryMu.RLock()
+	reg, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown stt backend %q", name)
+	}
+	return reg.factory()
+}
+
+// Tool returns the STT backend configured in STT_TOOL
+func Tool() string {
+	return os.Getenv("STT_TOOL")
+}
+
+// languageHint returns the language if it is known
+func languageHint(language string) string {
+	if language == "none" {
+		return ""
+	}
+	return language
+}
+
+// file returns the content of the audio as a file, with its name. PCM samples are sent as a wav file.
+func (a Audio) file() ([]byte, string, error) {
+	switch {
+	case len(a.PCM) != 0:
+		return common.EncodeWav(a.PCM, a.SampleRate), "audio.wav", nil
+	case len(a.Data) != 0:
+		return a.Data, a.FileName, nil
+	default:
+		data, err := os.ReadFile(a.FilePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read audio file: %w", err)
+		}
+		return data, a.FilePath, nil
+	}
+}
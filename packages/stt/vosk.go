@@ -0,0 +1,91 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// voskSampleRate is the sample rate used to convert audio files for vosk
+	voskSampleRate = 16000
+	// voskChunkSize is the size of the audio messages sent to vosk, 0.2s at 16kHz
+	voskChunkSize = 6400
+)
+
+func init() {
+	Register("vosk", []string{"VOSK_URL"}, func() (STT, error) {
+		return Vosk{Url: os.Getenv("VOSK_URL")}, nil
+	})
+}
+
+// Vosk transcribes with a vosk-server via websocket. The language is the one of the model loaded in the server.
+// Audio files are converted to PCM with ffmpeg.
+type Vosk struct {
+	// Url of the server, e.g. ws://vosk:2700
+	Url string
+}
+
+type voskResult struct {
+	Text string `json:"text"`
+}
+
+func (v Vosk) Transcribe(ctx context.Context, audio Audio, language string) (string, error) {
+	pcm, sampleRate := audio.PCM, audio.SampleRate
+	if len(pcm) == 0 {
+		data, _, err := audio.file()
+		if err != nil {
+			return "", err
+		}
+		pcm, err = common.ExecuteCommandContext(ctx, bytes.NewReader(data), "ffmpeg", "-loglevel", "error", "-i", "pipe:0",
+			"-f", "s16le", "-ac", "1", "-ar", strconv.Itoa(voskSampleRate), "pipe:1")
+		if err != nil {
+			return "", fmt.Errorf("failed to convert audio file: %w", err)
+		}
+		sampleRate = voskSampleRate
+	}
+
+	c, _, err := websocket.DefaultDialer.DialContext(ctx, v.Url, nil)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if err := c.WriteJSON(map[string]any{"config": map[string]int{"sample_rate": sampleRate}}); err != nil {
+		return "", err
+	}
+
+	var texts []string
+	// vosk answers each message with a partial result, or with the text of an utterance when it detects its end
+	read := func() error {
+		var result voskResult
+		if err := c.ReadJSON(&result); err != nil {
+			return err
+		}
+		if result.Text != "" {
+			texts = append(texts, result.Text)
+		}
+		return nil
+	}
+	for i := 0; i < len(pcm); i += voskChunkSize {
+		if err := c.WriteMessage(websocket.BinaryMessage, pcm[i:min(i+voskChunkSize, len(pcm))]); err != nil {
+			return "", err
+		}
+		if err := read(); err != nil {
+			return "", err
+		}
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte(`{"eof" : 1}`)); err != nil {
+		return "", err
+	}
+	if err := read(); err != nil {
+		return "", err
+	}
+	return strings.Join(texts, " "), nil
+}
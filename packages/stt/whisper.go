@@ -0,0 +1,41 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	Register("whisper", []string{"OPENAI_TOKEN"}, func() (STT, error) {
+		return Whisper{Client: openai.NewClient(os.Getenv("OPENAI_TOKEN"))}, nil
+	})
+}
+
+// Whisper transcribes with the OpenAI whisper API
+type Whisper struct {
+	Client *openai.Client
+}
+
+func (w Whisper) Transcribe(ctx context.Context, audio Audio, language string) (string, error) {
+	req := openai.AudioRequest{
+		Model:    openai.Whisper1,
+		FilePath: audio.FilePath,
+		Language: languageHint(language),
+	}
+	if audio.FilePath == "" {
+		data, name, err := audio.file()
+		if err != nil {
+			return "", err
+		}
+		req.FilePath = name
+		req.Reader = bytes.NewReader(data)
+	}
+	resp, err := w.Client.CreateTranscription(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
@@ -0,0 +1,58 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+func init() {
+	Register("whisper-cpp", []string{"WHISPER_CPP_URL"}, func() (STT, error) {
+		return WhisperCpp{Url: os.Getenv("WHISPER_CPP_URL")}, nil
+	})
+}
+
+// WhisperCpp transcribes with a whisper.cpp server. Start the server with --convert to transcribe audio files that are not wav.
+type WhisperCpp struct {
+	// Url of the server, e.g. http://whisper-cpp:8080
+	Url string
+}
+
+func (w WhisperCpp) Transcribe(ctx context.Context, audio Audio, language string) (string, error) {
+	data, name, err := audio.file()
+	if err != nil {
+		return "", err
+	}
+	if language = languageHint(language); language == "" {
+		language = "auto"
+	}
+
+	request := &common.PostHttpReq{
+		Url:           strings.TrimSuffix(w.Url, "/") + "/inference",
+		FileParamName: "file",
+		FileData:      data,
+		FileName:      name,
+		FormParams: map[string]string{
+			"response_format": "json",
+			"language":        language,
+		},
+	}
+	resp, err := request.SendPostWithContext(ctx, "form-data")
+	if err != nil {
+		return "", err
+	}
+	body, err := common.ProcessResponseString(resp)
+	if err != nil {
+		return "", err
+	}
+
+	var transcription common.WsResponse
+	if err := json.Unmarshal([]byte(body), &transcription); err != nil {
+		return "", fmt.Errorf("error unmarshaling JSON: %w", err)
+	}
+	return strings.TrimSpace(transcription.Text), nil
+}
@@ -0,0 +1,73 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+func init() {
+	Register("whisper-local", []string{"WHISPER_LOCAL_URL"}, func() (STT, error) {
+		return WhisperLocal{Url: os.Getenv("WHISPER_LOCAL_URL")}, nil
+	})
+}
+
+// WhisperLocal transcribes with a faster-whisper-server. In memory audio is streamed via websocket.
+type WhisperLocal struct {
+	// Url is the host and base path of the server, e.g. whisper_cpu:8000/v1
+	Url string
+}
+
+func (w WhisperLocal) Transcribe(ctx context.Context, audio Audio, language string) (string, error) {
+	slog.Debug("Transcribing audio using whisper-local")
+	if len(audio.PCM) != 0 {
+		request := &common.WsReq{
+			Url:  w.endpoint("ws", language, audio.SampleRate),
+			Data: audio.PCM,
+		}
+		return request.SendWsMessage(ctx)
+	}
+
+	request := &common.PostHttpReq{
+		Url:           w.endpoint("http", "", 0),
+		FileParamName: "file",
+		FilePath:      audio.FilePath,
+		FileData:      audio.Data,
+		FileName:      audio.FileName,
+	}
+	if language = languageHint(language); language != "" {
+		request.FormParams = map[string]string{"language": language}
+	}
+	resp, err := request.SendPostWithContext(ctx, "form-data")
+	if err != nil {
+		return "", err
+	}
+	return common.ProcessResponseString(resp)
+}
+
+// NewStream opens a streaming transcription session via websocket
+func (w WhisperLocal) NewStream(ctx context.Context, sampleRate int, language string) (Stream, error) {
+	return DialWsStream(ctx, w.endpoint("ws", language, sampleRate))
+}
+
+// endpoint returns the URL of the transcriptions, with the language and the sample rate of the PCM audio when
+// they are known
+func (w WhisperLocal) endpoint(scheme string, language string, sampleRate int) string {
+	endpoint := fmt.Sprintf("%s://%s/%s", scheme, w.Url, "audio/transcriptions")
+	query := url.Values{}
+	if language = languageHint(language); language != "" {
+		query.Set("language", language)
+	}
+	if sampleRate != 0 {
+		query.Set("sample_rate", strconv.Itoa(sampleRate))
+	}
+	if len(query) != 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
@@ -0,0 +1,171 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsStreamCommitTimeout is how long Commit waits for the transcription of the last audio sent
+	wsStreamCommitTimeout = 1500 * time.Millisecond
+	wsStreamBufferSize    = 32
+)
+
+// WsStream is a streaming transcription session over a websocket. It is opened once per call,
+// the audio is written frame by frame as it arrives and the server keeps sending the transcription
+// of all the audio received, which is surfaced through Transcripts.
+type WsStream struct {
+	conn        *websocket.Conn
+	writeMu     sync.Mutex
+	transcripts chan Transcript
+	updated     chan struct{}
+	done        chan struct{}
+
+	mu sync.Mutex
+	// words is the transcription of the whole session, committed the number of words already returned as final
+	words     []string
+	committed int
+	err       error
+	finished  bool
+	// lastWrite and lastUpdate tell if the server already answered to the last audio sent
+	lastWrite  time.Time
+	lastUpdate time.Time
+}
+
+// DialWsStream opens a streaming transcription session
+func DialWsStream(ctx context.Context, url string) (*WsStream, error) {
+	c, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &WsStream{
+		conn:        c,
+		transcripts: make(chan Transcript, wsStreamBufferSize),
+		updated:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	go s.readTranscriptions()
+	return s, nil
+}
+
+// Transcripts returns the channel where partial and final transcripts are sent.
+// It is closed when the session ends.
+func (s *WsStream) Transcripts() <-chan Transcript {
+	return s.transcripts
+}
+
+// Write sends a frame of audio to the server
+func (s *WsStream) Write(frame []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	s.lastWrite = time.Now()
+	s.mu.Unlock()
+	return s.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// Commit closes the current utterance: it waits for the transcription of the audio already sent
+// and returns the text transcribed since the previous commit as final transcript.
+func (s *WsStream) Commit(ctx context.Context) (Transcript, error) {
+	s.mu.Lock()
+	answered := !s.lastUpdate.Before(s.lastWrite)
+	s.mu.Unlock()
+	if !answered {
+		// Discard the notification of previous transcriptions, only a new one covers the last audio sent
+		select {
+		case <-s.updated:
+		default:
+		}
+		timer := time.NewTimer(wsStreamCommitTimeout)
+		defer timer.Stop()
+		select {
+		case <-s.updated:
+		case <-timer.C:
+		case <-s.done:
+		case <-ctx.Done():
+			return Transcript{}, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Transcript{}, s.err
+	}
+	final := Transcript{Text: strings.Join(s.words[min(s.committed, len(s.words)):], " "), Final: true}
+	s.committed = len(s.words)
+	s.send(final)
+	return final, nil
+}
+
+// Close ends the session
+func (s *WsStream) Close() error {
+	s.writeMu.Lock()
+	err := s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	s.writeMu.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(time.Second):
+	}
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readTranscriptions receives the transcriptions from the server and sends the not committed text as partial transcript
+func (s *WsStream) readTranscriptions() {
+	defer close(s.done)
+	for {
+		_, message, err := s.conn.ReadMessage()
+		if err != nil {
+			s.mu.Lock()
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				s.err = err
+			}
+			s.finished = true
+			close(s.transcripts)
+			s.mu.Unlock()
+			return
+		}
+
+		var wsResp common.WsResponse
+		if err := json.Unmarshal(message, &wsResp); err != nil {
+			slog.Warn(fmt.Sprintf("invalid transcription received: %s", err))
+			continue
+		}
+
+		s.mu.Lock()
+		s.lastUpdate = time.Now()
+		s.words = strings.Fields(wsResp.Text)
+		if s.committed < len(s.words) {
+			s.send(Transcript{Text: strings.Join(s.words[s.committed:], " ")})
+		}
+		s.mu.Unlock()
+
+		select {
+		case s.updated <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// send delivers the transcript without blocking the session, dropping it when nobody is reading.
+// It must be called holding mu.
+func (s *WsStream) send(t Transcript) {
+	if s.finished {
+		return
+	}
+	select {
+	case s.transcripts <- t:
+	default:
+	}
+}
@@ -0,0 +1,238 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/gorilla/websocket"
+)
+
+// fakeWhisper is a websocket transcription server. The frames of the test carry words instead of audio, and the
+// server answers each one with the transcription of all the audio received, as faster-whisper-server does.
+type fakeWhisper struct {
+	*httptest.Server
+	// frames receives the frames in the order they arrive
+	frames chan string
+	// queries receives the query of each connection
+	queries chan url.Values
+	// drop makes the server close the connection without the closing handshake after this number of frames
+	drop int
+	// silent makes the server never answer
+	silent bool
+}
+
+func newFakeWhisper(t *testing.T) *fakeWhisper {
+	f := &fakeWhisper{frames: make(chan string, 64), queries: make(chan url.Values, 4)}
+	upgrader := websocket.Upgrader{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			http.NotFound(w, r)
+			return
+		}
+		f.queries <- r.URL.Query()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		var words []string
+		for received := 1; ; received++ {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind != websocket.BinaryMessage {
+				t.Errorf("got message of type %d, want binary audio", kind)
+				continue
+			}
+			f.frames <- string(data)
+			if received == f.drop {
+				return
+			}
+			if f.silent {
+				continue
+			}
+			words = append(words, string(data))
+			answer, _ := json.Marshal(common.WsResponse{Text: strings.Join(words, " ")})
+			if err := conn.WriteMessage(websocket.TextMessage, answer); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeWhisper) whisper() WhisperLocal {
+	return WhisperLocal{Url: strings.TrimPrefix(f.URL, "http://") + "/v1"}
+}
+
+// nextTranscript waits for the next transcript of the stream
+func nextTranscript(t *testing.T, s Stream) Transcript {
+	t.Helper()
+	select {
+	case tr, ok := <-s.Transcripts():
+		if !ok {
+			t.Fatal("transcripts closed")
+		}
+		return tr
+	case <-time.After(2 * time.Second):
+		t.Fatal("no transcript received")
+	}
+	return Transcript{}
+}
+
+func TestWsStream(t *testing.T) {
+	f := newFakeWhisper(t)
+	ctx := context.Background()
+	s, err := f.whisper().NewStream(ctx, 8000, "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	query := <-f.queries
+	if query.Get("language") != "es" || query.Get("sample_rate") != "8000" {
+		t.Errorf("got query %v, want the language and the sample rate", query)
+	}
+
+	turns := [][]string{{"hello", "my", "friend"}, {"how", "are", "you"}}
+	for _, words := range turns {
+		for i, word := range words {
+			if err := s.Write([]byte(word)); err != nil {
+				t.Fatal(err)
+			}
+			// Each frame is pushed as it is written, not when the turn ends
+			select {
+			case frame := <-f.frames:
+				if frame != word {
+					t.Fatalf("server got %q, want %q", frame, word)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("frame %q was not pushed", word)
+			}
+			want := Transcript{Text: strings.Join(words[:i+1], " ")}
+			if got := nextTranscript(t, s); got != want {
+				t.Errorf("got %+v, want partial %+v", got, want)
+			}
+		}
+
+		// Commit ends the turn, the text of the previous turns is not repeated
+		want := Transcript{Text: strings.Join(words, " "), Final: true}
+		final, err := s.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if final != want {
+			t.Errorf("commit returned %+v, want %+v", final, want)
+		}
+		if got := nextTranscript(t, s); got != want {
+			t.Errorf("got %+v, want final %+v", got, want)
+		}
+	}
+
+	// A commit without new audio is empty
+	if final, err := s.Commit(ctx); err != nil || final != (Transcript{Final: true}) {
+		t.Errorf("got %+v %v, want an empty final transcript", final, err)
+	}
+	nextTranscript(t, s)
+
+	if err := s.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if _, ok := <-s.Transcripts(); ok {
+		t.Error("transcripts not closed after close")
+	}
+}
+
+func TestWsStreamCommitWaitsForTheLastAudio(t *testing.T) {
+	f := newFakeWhisper(t)
+	s, err := DialWsStream(context.Background(), strings.Replace(f.URL, "http", "ws", 1)+"/v1/audio/transcriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Commit right after writing, before the partial transcript is read
+	s.Write([]byte("quick"))
+	final, err := s.Commit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.Text != "quick" {
+		t.Errorf("got %q, want the transcription of the last audio", final.Text)
+	}
+}
+
+func TestWsStreamServerDrops(t *testing.T) {
+	f := newFakeWhisper(t)
+	f.drop = 2
+	s, err := f.whisper().NewStream(context.Background(), 8000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Write([]byte("one"))
+	nextTranscript(t, s)
+	s.Write([]byte("two"))
+
+	// The transcripts are closed when the connection is lost
+	timeout := time.After(2 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-s.Transcripts():
+			closed = !ok
+		case <-timeout:
+			t.Fatal("transcripts not closed when the server dropped the connection")
+		}
+	}
+	if _, err := s.Commit(context.Background()); err == nil {
+		t.Error("commit succeeded after the server dropped the connection")
+	}
+
+	start := time.Now()
+	s.Close()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("close took %v after the server dropped the connection", elapsed)
+	}
+}
+
+func TestWhisperLocalTranscribePCM(t *testing.T) {
+	f := newFakeWhisper(t)
+	text, err := f.whisper().Transcribe(context.Background(), Audio{PCM: []byte("hola"), SampleRate: 8000}, "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hola" {
+		t.Errorf("got %q, want %q", text, "hola")
+	}
+	query := <-f.queries
+	if query.Get("language") != "es" || query.Get("sample_rate") != "8000" {
+		t.Errorf("got query %v, want the language and the sample rate", query)
+	}
+}
+
+func TestWhisperLocalTranscribeCancel(t *testing.T) {
+	f := newFakeWhisper(t)
+	f.silent = true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := f.whisper().Transcribe(ctx, Audio{PCM: []byte("hola"), SampleRate: 8000}, "")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want the error of ctx", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("transcription took %v after ctx was done", elapsed)
+	}
+}
@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultApiUrl is the Bot API of Telegram, TELEGRAM_API_URL replaces it by a local Bot API server
+const defaultApiUrl = "https://api.telegram.org"
+
+// maxFileSize is the maximum size in bytes of the files downloaded from Telegram
+const maxFileSize = 20 * 1024 * 1024
+
+// botApi calls the methods of the Telegram Bot API
+type botApi struct {
+	baseUrl string
+	token   string
+	client  *http.Client
+}
+
+type update struct {
+	UpdateId      int64          `json:"update_id"`
+	Message       *message       `json:"message"`
+	CallbackQuery *callbackQuery `json:"callback_query"`
+}
+
+// chatId returns the chat of the message or of the button pressed, false for the other updates
+func (u update) chatId() (int64, bool) {
+	switch {
+	case u.Message != nil:
+		return u.Message.Chat.Id, true
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		return u.CallbackQuery.Message.Chat.Id, true
+	default:
+		return 0, false
+	}
+}
+
+type message struct {
+	MessageId int64  `json:"message_id"`
+	Chat      chat   `json:"chat"`
+	Text      string `json:"text"`
+	Caption   string `json:"caption"`
+	Voice     *file  `json:"voice"`
+	Audio     *file  `json:"audio"`
+}
+
+type chat struct {
+	Id int64 `json:"id"`
+}
+
+type file struct {
+	FileId   string `json:"file_id"`
+	FilePath string `json:"file_path"`
+	MimeType string `json:"mime_type"`
+	FileSize int64  `json:"file_size"`
+}
+
+// callbackQuery is sent when the user presses a button of an inline keyboard
+type callbackQuery struct {
+	Id      string   `json:"id"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// call sends the request to the method of the Bot API and reads its result
+func (b botApi) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("error converting data to JSON: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/bot%s/%s", b.baseUrl, b.token, method), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request to %s: %w", method, withoutUrl(err))
+	}
+	defer resp.Body.Close()
+
+	var apiResp struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("error reading response of %s: %s", method, resp.Status)
+	}
+	if !apiResp.Ok {
+		return fmt.Errorf("error response from %s: %s", method, apiResp.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(apiResp.Result, result)
+	}
+	return nil
+}
+
+// getUpdates waits for new updates, up to timeout
+func (b botApi) getUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]update, error) {
+	var updates []update
+	err := b.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"message", "callback_query"},
+	}, &updates)
+	return updates, err
+}
+
+func (b botApi) sendMessage(ctx context.Context, chatId int64, text string, keyboard *inlineKeyboardMarkup) error {
+	params := map[string]interface{}{"chat_id": chatId, "text": text}
+	if keyboard != nil {
+		params["reply_markup"] = keyboard
+	}
+	return b.call(ctx, "sendMessage", params, nil)
+}
+
+// sendPhoto sends the image in fileUrl, Telegram downloads it
+func (b botApi) sendPhoto(ctx context.Context, chatId int64, fileUrl string, caption string) error {
+	return b.call(ctx, "sendPhoto", map[string]interface{}{"chat_id": chatId, "photo": fileUrl, "caption": caption}, nil)
+}
+
+// sendDocument sends the file in fileUrl, Telegram downloads it
+func (b botApi) sendDocument(ctx context.Context, chatId int64, fileUrl string) error {
+	return b.call(ctx, "sendDocument", map[string]interface{}{"chat_id": chatId, "document": fileUrl}, nil)
+}
+
+func (b botApi) answerCallbackQuery(ctx context.Context, id string) error {
+	return b.call(ctx, "answerCallbackQuery", map[string]interface{}{"callback_query_id": id}, nil)
+}
+
+// downloadFile gets the content of the file sent by the user
+func (b botApi) downloadFile(ctx context.Context, fileId string) ([]byte, error) {
+	var f file
+	if err := b.call(ctx, "getFile", map[string]interface{}{"file_id": fileId}, &f); err != nil {
+		return nil, err
+	}
+	if f.FileSize > maxFileSize {
+		return nil, fmt.Errorf("file bigger than %d bytes", maxFileSize)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/file/bot%s/%s", b.baseUrl, b.token, f.FilePath), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", withoutUrl(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("error downloading file: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxFileSize))
+}
+
+// withoutUrl removes the URL from the errors of the HTTP client, as it contains the token
+func withoutUrl(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/stt"
+)
+
+const (
+	// pollTimeout is how long each request for updates waits for new messages
+	pollTimeout = 30 * time.Second
+	// retryDelay is the wait after a failed request for updates
+	retryDelay = 3 * time.Second
+)
+
+var sttEngine stt.STT
+
+// Server is the telegram channel, it talks with the users of the bot through the Bot API using long polling
+type Server struct {
+	api      botApi
+	chats    *channels.StateStore[int64, channels.Conversation]
+	messages sync.WaitGroup
+	// pending are the updates waiting to be answered by chat, a chat has an entry while its updates are answered
+	mu      sync.Mutex
+	pending map[int64][]update
+}
+
+// New creates the telegram channel with the shared engines
+func New(engines channels.Engines) (*Server, error) {
+	sttEngine = engines.STT
+	baseUrl := os.Getenv("TELEGRAM_API_URL")
+	if baseUrl == "" {
+		baseUrl = defaultApiUrl
+	}
+	return &Server{
+		api: botApi{
+			baseUrl: strings.TrimSuffix(baseUrl, "/"),
+			token:   os.Getenv("TELEGRAM_BOT_TOKEN"),
+			client:  &http.Client{Timeout: pollTimeout + 30*time.Second},
+		},
+		chats:   channels.NewStateStore[int64, channels.Conversation](channels.ConversationTTL),
+		pending: make(map[int64][]update),
+	}, nil
+}
+
+func (srv *Server) Name() string {
+	return "telegram"
+}
+
+// Start receives the messages of the users until ctx is done
+func (srv *Server) Start(ctx context.Context) error {
+	slog.Info(fmt.Sprintf("Polling updates from %s", srv.api.baseUrl))
+	var offset int64
+	for {
+		updates, err := srv.api.getUpdates(ctx, offset, pollTimeout)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error getting updates: %s", err))
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateId + 1
+			srv.queue(ctx, u)
+		}
+	}
+}
+
+// queue answers the update after the previous ones of its chat, so the messages of a chat are answered in order
+// while the chats are answered at once
+func (srv *Server) queue(ctx context.Context, u update) {
+	chatId, ok := u.chatId()
+	if !ok {
+		return
+	}
+	srv.mu.Lock()
+	pending, answering := srv.pending[chatId]
+	srv.pending[chatId] = append(pending, u)
+	srv.mu.Unlock()
+	if answering {
+		return
+	}
+
+	srv.messages.Add(1)
+	go func() {
+		defer srv.messages.Done()
+		for {
+			srv.mu.Lock()
+			pending := srv.pending[chatId]
+			if len(pending) == 0 {
+				delete(srv.pending, chatId)
+				srv.mu.Unlock()
+				return
+			}
+			srv.pending[chatId] = pending[1:]
+			srv.mu.Unlock()
+			srv.handleUpdate(ctx, chatId, pending[0])
+		}
+	}()
+}
+
+// Stop waits until the messages being answered are done
+func (srv *Server) Stop() error {
+	srv.messages.Wait()
+	return nil
+}
+
+// handleUpdate answers a message, or the press of a button, of the chat
+func (srv *Server) handleUpdate(ctx context.Context, chatId int64, u update) {
+	if u.CallbackQuery != nil {
+		if err := srv.api.answerCallbackQuery(ctx, u.CallbackQuery.Id); err != nil {
+			slog.Warn(fmt.Sprintf("Error answering callback query: %s", err), "chat", chatId)
+		}
+	}
+	current, _ := srv.chats.Get(chatId)
+
+	var text string
+	switch {
+	case u.CallbackQuery != nil:
+		// The data of the buttons is the number of the option, chosen like a number answered by the user
+		slog.Info("Received button press", "chat", chatId)
+		if _, ok := current.Buttons.Match(u.CallbackQuery.Data); !ok {
+			return
+		}
+		text = u.CallbackQuery.Data
+	case u.Message.Voice != nil || u.Message.Audio != nil:
+		slog.Info("Received audio message", "chat", chatId)
+		transcription, err := srv.transcribeAudio(ctx, u.Message)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error transcribing audio message: %s", err), "chat", chatId)
+			return
+		}
+		text = transcription
+	case u.Message.Text != "":
+		slog.Info("Received text message", "chat", chatId)
+		text = u.Message.Text
+	default:
+		return
+	}
+	slog.Debug(fmt.Sprintf("message received: %s", text), "chat", chatId)
+
+	save := func() { srv.chats.Save(chatId, current) }
+	responses, err := current.Ask(ctx, fmt.Sprintf("telegram-%d", chatId), "", common.TextMessage(text), save)
+	if err != nil {
+		slog.Error(err.Error(), "chat", chatId)
+		return
+	}
+	for _, r := range responses {
+		if err := srv.sendResponse(ctx, chatId, r); err != nil {
+			slog.Error(fmt.Sprintf("Error sending response: %s", err), "chat", chatId)
+		}
+	}
+}
+
+// transcribeAudio downloads the voice message and transcribes it
+func (srv *Server) transcribeAudio(ctx context.Context, m *message) (string, error) {
+	audio := m.Voice
+	fileName := "voice.ogg"
+	if audio == nil {
+		audio = m.Audio
+		fileName = "audio" + audioExtension(audio.MimeType)
+	}
+	data, err := srv.api.downloadFile(ctx, audio.FileId)
+	if err != nil {
+		return "", fmt.Errorf("failed to download audio: %w", err)
+	}
+	return sttEngine.Transcribe(ctx, stt.Audio{Data: data, FileName: fileName}, "")
+}
+
+// sendResponse renders the response of the assistant: the buttons as an inline keyboard, and the image and the
+// attachment as photo and document
+func (srv *Server) sendResponse(ctx context.Context, chatId int64, r common.Response) error {
+	text := r.Text
+	if r.Image != "" && len(r.Buttons) == 0 {
+		// The text is the caption of the image
+		if err := srv.api.sendPhoto(ctx, chatId, r.Image, text); err != nil {
+			return err
+		}
+		text = ""
+	}
+	if text != "" || len(r.Buttons) > 0 {
+		if text == "" {
+			text = r.Menu()
+		}
+		if err := srv.api.sendMessage(ctx, chatId, text, keyboard(r.Buttons)); err != nil {
+			return err
+		}
+	}
+	if r.Image != "" && len(r.Buttons) > 0 {
+		if err := srv.api.sendPhoto(ctx, chatId, r.Image, ""); err != nil {
+			return err
+		}
+	}
+	if r.Attachment != nil {
+		if err := srv.api.sendDocument(ctx, chatId, r.Attachment.URL); err != nil {
+			return err
+		}
+	}
+	if r.Custom != nil {
+		slog.Debug(fmt.Sprintf("custom payload can't be shown in telegram: %v", r.Custom), "chat", chatId)
+	}
+	return nil
+}
+
+// keyboard returns an inline keyboard with a button per row, the data of each one is its number
+func keyboard(buttons common.Buttons) *inlineKeyboardMarkup {
+	if len(buttons) == 0 {
+		return nil
+	}
+	markup := &inlineKeyboardMarkup{}
+	for i, b := range buttons {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []inlineKeyboardButton{{Text: b.Title, CallbackData: strconv.Itoa(i + 1)}})
+	}
+	return markup
+}
+
+// audioExtension returns the extension of the audio files sent by the users, used by the STT tool to read it
+func audioExtension(mimeType string) string {
+	switch mimeType {
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/mp4", "audio/x-m4a":
+		return ".m4a"
+	case "audio/wav", "audio/x-wav":
+		return ".wav"
+	default:
+		return ".ogg"
+	}
+}
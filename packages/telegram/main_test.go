@@ -0,0 +1,288 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+const testToken = "123:secret"
+
+// fakeAssistant offers a menu for the first message and confirms the option chosen
+type fakeAssistant struct {
+	messages chan string
+	senders  chan string
+}
+
+var assistant = &fakeAssistant{messages: make(chan string, 16), senders: make(chan string, 16)}
+
+func init() {
+	assistants.Register("telegram-test", nil, func() (assistants.Assistant, error) { return assistant, nil })
+}
+
+func (a *fakeAssistant) Interact(ctx context.Context, sender string, language string, m common.Message) (common.Responses, error) {
+	a.messages <- m.Text
+	a.senders <- sender
+	if strings.HasPrefix(m.Text, "/") {
+		return common.Responses{{RecipientId: sender, Text: "You chose " + m.Text}}, nil
+	}
+	return common.Responses{{
+		RecipientId: sender,
+		Text:        "What do you want to do?",
+		Buttons:     common.Buttons{{Title: "Pay my bill", Payload: "/pay"}, {Title: "Talk to an agent", Payload: "/agent"}},
+	}}, nil
+}
+
+// botApiStub is a Telegram Bot API serving the queued updates and recording the calls of the bot
+type botApiStub struct {
+	*httptest.Server
+	mu      sync.Mutex
+	updates []update
+	// offsets are the offsets of the getUpdates calls
+	offsets []int64
+	// calls receives the methods called, other than getUpdates, with their parameters
+	calls chan stubCall
+}
+
+type stubCall struct {
+	method string
+	params map[string]json.RawMessage
+}
+
+func newBotApiStub(t *testing.T) *botApiStub {
+	stub := &botApiStub{calls: make(chan stubCall, 16)}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, "/bot"+testToken+"/")
+		if !ok {
+			t.Errorf("request to %s without the token", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var params map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Errorf("invalid parameters of %s: %v", method, err)
+		}
+
+		var result interface{} = true
+		switch method {
+		case "getUpdates":
+			var offset int64
+			json.Unmarshal(params["offset"], &offset)
+			result = stub.poll(r.Context(), offset)
+		default:
+			stub.calls <- stubCall{method: method, params: params}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// poll returns the updates after the offset, confirming the previous ones as the Bot API does.
+// It waits a bit for new updates when there are none, like a long poll.
+func (stub *botApiStub) poll(ctx context.Context, offset int64) []update {
+	stub.mu.Lock()
+	stub.offsets = append(stub.offsets, offset)
+	pending := []update{}
+	for _, u := range stub.updates {
+		if u.UpdateId >= offset {
+			pending = append(pending, u)
+		}
+	}
+	stub.updates = pending
+	stub.mu.Unlock()
+	if len(pending) == 0 {
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+		}
+	}
+	return pending
+}
+
+func (stub *botApiStub) push(u update) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.updates = append(stub.updates, u)
+}
+
+// next waits for the next call of the bot
+func (stub *botApiStub) next(t *testing.T) stubCall {
+	t.Helper()
+	select {
+	case call := <-stub.calls:
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("the bot didn't call the API")
+	}
+	return stubCall{}
+}
+
+func nextMessage(t *testing.T) string {
+	t.Helper()
+	select {
+	case m := <-assistant.messages:
+		return m
+	case <-time.After(30 * time.Second):
+		// The language detector is loaded with the first message
+		t.Fatal("the assistant didn't get the message")
+	}
+	return ""
+}
+
+func TestTelegram(t *testing.T) {
+	t.Setenv("ASSISTANT_TOOL", "telegram-test")
+	stub := newBotApiStub(t)
+	t.Setenv("TELEGRAM_API_URL", stub.URL+"/")
+	t.Setenv("TELEGRAM_BOT_TOKEN", testToken)
+	srv, err := New(channels.Engines{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Start(ctx) }()
+
+	// A message of the user is answered with the options as an inline keyboard
+	stub.push(update{UpdateId: 100, Message: &message{MessageId: 1, Chat: chat{Id: 42}, Text: "Hello, I need help with my bill"}})
+	if got := nextMessage(t); got != "Hello, I need help with my bill" {
+		t.Errorf("assistant got %q", got)
+	}
+	if sender := <-assistant.senders; sender != "telegram-42" {
+		t.Errorf("assistant got sender %q, want telegram-42", sender)
+	}
+	call := stub.next(t)
+	if call.method != "sendMessage" {
+		t.Fatalf("bot called %s, want sendMessage", call.method)
+	}
+	var chatId int64
+	var text string
+	var markup inlineKeyboardMarkup
+	json.Unmarshal(call.params["chat_id"], &chatId)
+	json.Unmarshal(call.params["text"], &text)
+	json.Unmarshal(call.params["reply_markup"], &markup)
+	if chatId != 42 || text != "What do you want to do?" {
+		t.Errorf("sent %q to chat %d", text, chatId)
+	}
+	want := [][]inlineKeyboardButton{{{Text: "Pay my bill", CallbackData: "1"}}, {{Text: "Talk to an agent", CallbackData: "2"}}}
+	if got, _ := json.Marshal(markup.InlineKeyboard); string(got) != mustJSON(want) {
+		t.Errorf("sent keyboard %s, want %s", got, mustJSON(want))
+	}
+
+	// Pressing a button answers the callback query and sends the payload of the option
+	stub.push(update{UpdateId: 101, CallbackQuery: &callbackQuery{Id: "cb-1", Message: &message{Chat: chat{Id: 42}}, Data: "2"}})
+	call = stub.next(t)
+	var callbackId string
+	json.Unmarshal(call.params["callback_query_id"], &callbackId)
+	if call.method != "answerCallbackQuery" || callbackId != "cb-1" {
+		t.Errorf("bot called %s with %s, want the answer of the callback query", call.method, callbackId)
+	}
+	if got := nextMessage(t); got != "/agent" {
+		t.Errorf("assistant got %q, want the payload of the option", got)
+	}
+	<-assistant.senders
+	call = stub.next(t)
+	json.Unmarshal(call.params["text"], &text)
+	if call.method != "sendMessage" || text != "You chose /agent" {
+		t.Errorf("bot called %s with %q", call.method, text)
+	}
+	if _, ok := call.params["reply_markup"]; ok {
+		t.Error("keyboard sent without options")
+	}
+
+	// A button of an unknown menu is answered, but not sent to the assistant
+	stub.push(update{UpdateId: 102, CallbackQuery: &callbackQuery{Id: "cb-2", Message: &message{Chat: chat{Id: 7}}, Data: "1"}})
+	if call = stub.next(t); call.method != "answerCallbackQuery" {
+		t.Errorf("bot called %s, want the answer of the callback query", call.method)
+	}
+	select {
+	case m := <-assistant.messages:
+		t.Errorf("assistant got %q for a button of an unknown menu", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("start returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel didn't stop")
+	}
+	srv.Stop()
+
+	// Each update is confirmed in the next poll, and the offset never goes back
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.offsets[0] != 0 {
+		t.Errorf("first offset is %d, want 0", stub.offsets[0])
+	}
+	var confirmed []int64
+	for i := 1; i < len(stub.offsets); i++ {
+		if stub.offsets[i] < stub.offsets[i-1] {
+			t.Fatalf("offset went back from %d to %d", stub.offsets[i-1], stub.offsets[i])
+		}
+		if stub.offsets[i] != stub.offsets[i-1] {
+			confirmed = append(confirmed, stub.offsets[i])
+		}
+	}
+	if mustJSON(confirmed) != "[101,102,103]" {
+		t.Errorf("offsets after the updates are %v, want 101, 102 and 103", confirmed)
+	}
+	if len(stub.updates) != 0 {
+		t.Errorf("%d updates not confirmed", len(stub.updates))
+	}
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestTelegramAnswersEachChatInOrder(t *testing.T) {
+	t.Setenv("ASSISTANT_TOOL", "telegram-test")
+	stub := newBotApiStub(t)
+	t.Setenv("TELEGRAM_API_URL", stub.URL+"/")
+	t.Setenv("TELEGRAM_BOT_TOKEN", testToken)
+	srv, err := New(channels.Engines{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Start(ctx)
+
+	// The messages sent together are received in the same poll
+	texts := []string{"/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8"}
+	for i, text := range texts {
+		stub.push(update{UpdateId: int64(200 + i), Message: &message{MessageId: int64(i), Chat: chat{Id: 42}, Text: text}})
+	}
+	for _, want := range texts {
+		if got := nextMessage(t); got != want {
+			t.Errorf("assistant got %q, want %q", got, want)
+		}
+		<-assistant.senders
+		call := stub.next(t)
+		var text string
+		json.Unmarshal(call.params["text"], &text)
+		if text != "You chose "+want {
+			t.Errorf("bot sent %q, want the answer of %s", text, want)
+		}
+	}
+	cancel()
+	srv.Stop()
+	if len(srv.pending) != 0 {
+		t.Errorf("%d chats still pending", len(srv.pending))
+	}
+}
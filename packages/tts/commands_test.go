@@ -0,0 +1,159 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// stubEngines are the programs replaced by the test binary, see runStub
+var stubEngines = []string{"pico2wave", "espeak-ng", "piper"}
+
+// stubCall is what a stub program received, written as JSON to STUB_CALL
+type stubCall struct {
+	Args  []string `json:"args"`
+	Stdin string   `json:"stdin"`
+}
+
+// stubSamples is the audio written by the stub programs
+var stubSamples = []byte{1, 0, 2, 0, 3, 0}
+
+func TestMain(m *testing.M) {
+	if name := filepath.Base(os.Args[0]); contains(stubEngines, name) {
+		os.Exit(runStub(name))
+	}
+	os.Exit(m.Run())
+}
+
+// runStub acts as the engine when the test binary is run through one of the links created by installStubs.
+// It records its arguments and standard input and writes a wav to the output file of the engine, or to stdout.
+func runStub(name string) int {
+	args := os.Args[1:]
+	var stdin []byte
+	if name != "pico2wave" {
+		stdin, _ = io.ReadAll(os.Stdin)
+	}
+	call, _ := json.Marshal(stubCall{Args: args, Stdin: string(stdin)})
+	if err := os.WriteFile(os.Getenv("STUB_CALL"), call, 0o600); err != nil {
+		return 1
+	}
+
+	wav := common.EncodeWav(stubSamples, 16000)
+	for i, arg := range args {
+		if (arg == "-w" || arg == "--output_file") && i+1 < len(args) {
+			return exitCode(os.WriteFile(args[i+1], wav, 0o600))
+		}
+	}
+	_, err := os.Stdout.Write(wav)
+	return exitCode(err)
+}
+
+func exitCode(err error) int {
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// installStubs puts links to the test binary named after the engines first in PATH, and returns the file where
+// the stubs record their calls
+func installStubs(t *testing.T) string {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	for _, name := range stubEngines {
+		if err := os.Symlink(executable, filepath.Join(bin, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	callFile := filepath.Join(t.TempDir(), "call.json")
+	t.Setenv("STUB_CALL", callFile)
+	return callFile
+}
+
+func TestHostileTextReachesEnginesVerbatim(t *testing.T) {
+	callFile := installStubs(t)
+	// The text would create this file if it was interpreted by a shell
+	pwned := filepath.Join(t.TempDir(), "pwned")
+
+	texts := map[string]string{
+		"double quotes":        `He said "hello" and left"`,
+		"command substitution": "$(touch " + pwned + ")",
+		"backticks":            "`touch " + pwned + "`",
+		"semicolon":            "hi; touch " + pwned,
+		"leading dash":         "-w /etc/passwd",
+		"leading double dash":  "--help",
+		"newlines":             "first line\nsecond line\n; touch " + pwned,
+		"variables":            "$HOME ${PATH} $'\\x41'",
+	}
+	engines := []struct {
+		name string
+		tts  TTS
+		// textOf returns the text received by the engine
+		textOf func(call stubCall) string
+	}{
+		{"pico2wave", Pico{}, func(call stubCall) string {
+			// The text is the last argument, after -- so it is never read as an option
+			if n := len(call.Args); n >= 2 && call.Args[n-2] == "--" {
+				return call.Args[n-1]
+			}
+			return ""
+		}},
+		{"espeak-ng", Espeak{}, func(call stubCall) string { return call.Stdin }},
+		{"piper", Piper{Model: "voice.onnx"}, func(call stubCall) string { return call.Stdin }},
+	}
+
+	for _, engine := range engines {
+		for name, text := range texts {
+			t.Run(engine.name+"/"+name, func(t *testing.T) {
+				os.Remove(callFile)
+				pcm, sampleRate, err := engine.tts.Synthesize(context.Background(), text, "en")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(pcm) != string(stubSamples) || sampleRate != 16000 {
+					t.Errorf("unexpected audio %v at %dHz", pcm, sampleRate)
+				}
+
+				data, err := os.ReadFile(callFile)
+				if err != nil {
+					t.Fatalf("%s was not run: %v", engine.name, err)
+				}
+				var call stubCall
+				if err := json.Unmarshal(data, &call); err != nil {
+					t.Fatal(err)
+				}
+				if got := engine.textOf(call); got != text {
+					t.Errorf("%s received %q, want %q", engine.name, got, text)
+				}
+				// The text is never mixed with the options
+				for _, arg := range call.Args {
+					if arg != text && strings.Contains(arg, text) {
+						t.Errorf("argument %q contains the text", arg)
+					}
+				}
+				if _, err := os.Stat(pwned); err == nil {
+					t.Fatal("the text was executed")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+func init() {
+	Register("espeak", nil, func() (TTS, error) {
+		return Espeak{Voice: os.Getenv("ESPEAK_VOICE")}, nil
+	})
+}
+
+// Espeak generates the speech with espeak-ng, which has voices for many more languages than pico
+type Espeak struct {
+	// Voice overrides the voice chosen from the language, e.g. "pt-br" or "en-us+f3"
+	Voice string
+}
+
+func (e Espeak) Synthesize(ctx context.Context, text string, language string) ([]byte, int, error) {
+	// The text is sent through stdin and the wav is read from stdout, so no file is needed
+	args := []string{"-v", e.chooseVoice(language), "--stdin", "--stdout"}
+	slog.Debug(fmt.Sprintf("command to generate audio: espeak-ng %q", args))
+	out, err := common.ExecuteCommandContext(ctx, strings.NewReader(text), "espeak-ng", args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to generate audio: %w", err)
+	}
+	return common.DecodeWav(out)
+}
+
+// chooseVoice chooses the espeak-ng voice, its voices are named after the ISO 639-1 code of the language
+func (e Espeak) chooseVoice(language string) string {
+	if e.Voice != "" {
+		return e.Voice
+	}
+	if language == "" || language == "none" {
+		return "en"
+	}
+	return strings.ToLower(language)
+}
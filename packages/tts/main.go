@@ -0,0 +1,86 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+const defaultTool = "pico"
+
+// TTS is implemented by every engine able to convert text into speech
+type TTS interface {
+	// Synthesize returns the speech as PCM 16bit signed linear mono (little-endian) samples and its sample rate
+	Synthesize(ctx context.Context, text string, language string) ([]byte, int, error)
+}
+
+// Factory creates a new instance of a TTS engine
+type Factory func() (TTS, error)
+
+type registration struct {
+	factory     Factory
+	requiredEnv []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]registration{}
+)
+
+// Register makes a TTS engine available under the given name, which is the value used in TTS_TOOL.
+// requiredEnv are the env vars that must be set to use the engine.
+func Register(name string, requiredEnv []string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("tts: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("tts: Register called twice for engine " + name)
+	}
+	registry[name] = registration{factory: factory, requiredEnv: requiredEnv}
+}
+
+// Names returns the sorted list of the registered TTS engines
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RequiredEnv returns the env vars needed by the engine registered with the given name
+func RequiredEnv(name string) ([]string, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	reg, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tts engine %q", name)
+	}
+	return reg.requiredEnv, nil
+}
+
+// New creates the TTS engine registered with the given name
+func New(name string) (TTS, error) {
+	registryMu.RLock()
+	reg, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown tts engine %q", name)
+	}
+	return reg.factory()
+}
+
+// Tool returns the TTS engine configured in TTS_TOOL, pico when it is not set
+func Tool() string {
+	if tool := os.Getenv("TTS_TOOL"); tool != "" {
+		return tool
+	}
+	return defaultTool
+}
@@ -0,0 +1,64 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+func init() {
+	Register("pico", nil, func() (TTS, error) {
+		return Pico{}, nil
+	})
+}
+
+// Pico generates the speech with pico2wave
+type Pico struct{}
+
+func (p Pico) Synthesize(ctx context.Context, text string, language string) ([]byte, int, error) {
+	audioFile, err := os.CreateTemp("", "pico-*.wav")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create audio file: %w", err)
+	}
+	audioFile.Close()
+	defer os.Remove(audioFile.Name())
+
+	args := []string{"-l", choosePicoTtsLanguage(language), "-w", audioFile.Name(), "--", text}
+	slog.Debug(fmt.Sprintf("command to generate audio: pico2wave %q", args))
+	if _, err := common.ExecuteCommandContext(ctx, nil, "pico2wave", args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to generate audio: %w", err)
+	}
+	return readWavFile(audioFile.Name())
+}
+
+// choosePicoTtsLanguage chooses the language for the Pico TTS engine
+func choosePicoTtsLanguage(language string) string {
+	switch language {
+	case "en":
+		return "en-US"
+	case "es":
+		return "es-ES"
+	case "fr":
+		return "fr-FR"
+	case "de":
+		return "de-DE"
+	case "it":
+		return "it-IT"
+	// case "pt":
+	// 	return "pt-PT"
+	default:
+		return "en-US"
+	}
+}
+
+// readWavFile reads the PCM samples and sample rate of a wav file
+func readWavFile(path string) ([]byte, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	return common.DecodeWav(data)
+}
@@ -0,0 +1,84 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+func init() {
+	Register("piper", nil, newPiper)
+}
+
+// Piper generates the speech with Piper neural voices, running the piper binary
+// or calling a piper HTTP server when PIPER_URL is set.
+type Piper struct {
+	Url   string
+	Model string
+}
+
+func newPiper() (TTS, error) {
+	p := Piper{
+		Url:   os.Getenv("PIPER_URL"),
+		Model: os.Getenv("PIPER_MODEL"),
+	}
+	if p.Url == "" && p.Model == "" {
+		return nil, fmt.Errorf("PIPER_URL or PIPER_MODEL must be set to use piper")
+	}
+	return p, nil
+}
+
+func (p Piper) Synthesize(ctx context.Context, text string, language string) ([]byte, int, error) {
+	if p.Url != "" {
+		return p.synthesizeHttp(ctx, text)
+	}
+	return p.synthesizeBinary(ctx, text, language)
+}
+
+// chooseModel chooses the voice model for the language, set in PIPER_MODEL_<LANGUAGE>, e.g. PIPER_MODEL_PT
+func (p Piper) chooseModel(language string) string {
+	if model := os.Getenv("PIPER_MODEL_" + strings.ToUpper(language)); language != "" && model != "" {
+		return model
+	}
+	return p.Model
+}
+
+func (p Piper) synthesizeBinary(ctx context.Context, text string, language string) ([]byte, int, error) {
+	audioFile, err := os.CreateTemp("", "piper-*.wav")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create audio file: %w", err)
+	}
+	audioFile.Close()
+	defer os.Remove(audioFile.Name())
+
+	// piper reads the text from stdin
+	args := []string{"--model", p.chooseModel(language), "--output_file", audioFile.Name()}
+	slog.Debug(fmt.Sprintf("command to generate audio: piper %q", args))
+	if _, err := common.ExecuteCommandContext(ctx, strings.NewReader(text), "piper", args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to generate audio: %w", err)
+	}
+	return readWavFile(audioFile.Name())
+}
+
+// synthesizeHttp calls the piper HTTP server, which reads the text to speak from the whole body of the request
+func (p Piper) synthesizeHttp(ctx context.Context, text string) ([]byte, int, error) {
+	request := &common.PostHttpReq{
+		Url:      p.Url,
+		TextBody: text,
+	}
+	body, err := request.SendPostWithContext(ctx, "text")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to generate audio: %w", err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read generated audio: %w", err)
+	}
+	return common.DecodeWav(data)
+}
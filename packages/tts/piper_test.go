@@ -0,0 +1,49 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+func TestPiperHttp(t *testing.T) {
+	const text = `Say "hi" {"text": "not this"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The piper HTTP server speaks the whole body of the request
+		body, _ := io.ReadAll(r.Body)
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); r.Method != http.MethodPost || mediaType != "text/plain" {
+			t.Errorf("got %s request with content type %s, want a text/plain POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		if string(body) != text {
+			t.Errorf("got body %q, want the text", body)
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(common.EncodeWav(stubSamples, 22050))
+	}))
+	defer server.Close()
+
+	pcm, sampleRate, err := Piper{Url: server.URL}.Synthesize(context.Background(), text, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pcm, stubSamples) || sampleRate != 22050 {
+		t.Errorf("got %d samples at %dHz, want the audio of the server", len(pcm)/2, sampleRate)
+	}
+}
+
+func TestPiperHttpError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no voice loaded", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if _, _, err := (Piper{Url: server.URL}).Synthesize(context.Background(), "hello", "en"); err == nil {
+		t.Fatal("expected the error of the server")
+	}
+}
@@ -0,0 +1,66 @@
+package tts
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minSentenceLength is the minimum number of characters of a sentence, shorter ones are joined to the next
+// one so the speech doesn't sound choppy, e.g. "Hi! How are you?" is a single sentence.
+const minSentenceLength = 15
+
+// abbreviations end with a period without ending the sentence, in the languages most used with the bot
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true, "st": true,
+	"sra": true, "srta": true, "dra": true, "ud": true, "uds": true, "av": true, "etc": true, "vs": true,
+	"e.g": true, "i.e": true, "approx": true, "aprox": true,
+}
+
+// isAbbreviation tells whether the word ending at the period is an abbreviation, an initial, like the J. of
+// J. Smith, or the number of an item starting a line, like the 2. of a numbered list
+func isAbbreviation(runes []rune, period int) bool {
+	start := period
+	for start > 0 && !unicode.IsSpace(runes[start-1]) && runes[start-1] != '(' {
+		start--
+	}
+	word := []rune(strings.ToLower(string(runes[start:period])))
+	if abbreviations[string(word)] || (len(word) == 1 && unicode.IsLetter(word[0])) {
+		return true
+	}
+	lineStart := start == 0 || runes[start-1] == '\n'
+	return lineStart && len(word) > 0 && strings.Trim(string(word), "0123456789") == ""
+}
+
+// SplitSentences splits a text in sentences so they can be synthesized and played one by one
+func SplitSentences(text string) []string {
+	var sentences []string
+	var current strings.Builder
+	flush := func(force bool) {
+		sentence := strings.TrimSpace(current.String())
+		if sentence == "" || (!force && len([]rune(sentence)) < minSentenceLength) {
+			return
+		}
+		sentences = append(sentences, sentence)
+		current.Reset()
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		current.WriteRune(r)
+		switch r {
+		case '\n':
+			flush(false)
+		case '.', '!', '?', '…', '。', '！', '？':
+			// Only split when the punctuation ends a word, so numbers like 3.5 or urls are kept
+			if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+				continue
+			}
+			if r == '.' && i+1 < len(runes) && isAbbreviation(runes, i) {
+				continue
+			}
+			flush(false)
+		}
+	}
+	flush(true)
+	return sentences
+}
@@ -0,0 +1,83 @@
+package tts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "sentences",
+			text: "Your bill is ready to be paid. Do you want to pay it now? Great, let's go!",
+			want: []string{"Your bill is ready to be paid.", "Do you want to pay it now?", "Great, let's go!"},
+		},
+		{
+			name: "decimals",
+			text: "The total of your bill is 3.5 euros. It can be paid until Monday.",
+			want: []string{"The total of your bill is 3.5 euros.", "It can be paid until Monday."},
+		},
+		{
+			name: "abbreviations",
+			text: "Your appointment with Dr. Smith is on Monday. Please bring your card, ID, etc. when you come.",
+			want: []string{"Your appointment with Dr. Smith is on Monday.", "Please bring your card, ID, etc. when you come."},
+		},
+		{
+			name: "initials",
+			text: "The package was signed by J. R. Tolkien this morning. It arrived in time.",
+			want: []string{"The package was signed by J. R. Tolkien this morning.", "It arrived in time."},
+		},
+		{
+			name: "urls",
+			text: "You can pay it online at https://example.com/pay?bill=3. It only takes a minute.",
+			want: []string{"You can pay it online at https://example.com/pay?bill=3.", "It only takes a minute."},
+		},
+		{
+			name: "question and exclamation marks",
+			text: "Did you really pay it twice?! Let me check it for you right now.",
+			want: []string{"Did you really pay it twice?!", "Let me check it for you right now."},
+		},
+		{
+			name: "ellipsis",
+			text: "Let me think about your question... I found your last three bills. They are all paid…",
+			want: []string{"Let me think about your question...", "I found your last three bills.", "They are all paid…"},
+		},
+		{
+			name: "short fragments are joined",
+			text: "Hi! Sure. Your bill is ready to be paid.",
+			want: []string{"Hi! Sure. Your bill is ready to be paid."},
+		},
+		{
+			name: "short fragment at the end",
+			text: "Your bill is ready to be paid. Bye!",
+			want: []string{"Your bill is ready to be paid.", "Bye!"},
+		},
+		{
+			name: "lines",
+			text: "These are your options:\n1. Pay my bill\n2. Talk to an agent",
+			want: []string{"These are your options:", "1. Pay my bill\n2. Talk to an agent"},
+		},
+		{
+			name: "no punctuation at the end",
+			text: "Your bill is ready to be paid. You can pay it at any office",
+			want: []string{"Your bill is ready to be paid.", "You can pay it at any office"},
+		},
+		{
+			name: "empty",
+			text: "  \n ",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitSentences(tt.text)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
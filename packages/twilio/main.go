@@ -0,0 +1,165 @@
+package twilio
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultAddr = ":8085"
+	// startTimeout is how long the media stream has to send its start event after connecting
+	startTimeout = 10 * time.Second
+)
+
+// Server is the twilio channel, it answers the calls sent through Twilio Media Streams, or any media stream
+// WebSocket using the same JSON events
+type Server struct {
+	http     *http.Server
+	upgrader websocket.Upgrader
+	bot      *voice.Bot
+	calls    sync.WaitGroup
+	// authToken checks the signature of Twilio, made with the publicUrl of the server, and streamToken is the
+	// token of the other providers. Without them any media stream is answered.
+	authToken   string
+	publicUrl   string
+	streamToken string
+}
+
+// New creates the twilio channel with the shared engines, the TTS engine is mandatory
+func New(engines channels.Engines) (*Server, error) {
+	bot, err := voice.NewBot(engines)
+	if err != nil {
+		return nil, err
+	}
+	addr := os.Getenv("TWILIO_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	srv := &Server{
+		bot:         bot,
+		authToken:   os.Getenv("TWILIO_AUTH_TOKEN"),
+		publicUrl:   strings.TrimSuffix(os.Getenv("TWILIO_PUBLIC_URL"), "/"),
+		streamToken: os.Getenv("TWILIO_STREAM_TOKEN"),
+	}
+	if srv.authToken == "" && srv.streamToken == "" {
+		if os.Getenv("TWILIO_INSECURE") != "true" {
+			return nil, fmt.Errorf("TWILIO_AUTH_TOKEN or TWILIO_STREAM_TOKEN must be set to use the twilio channel, or TWILIO_INSECURE=true to answer any media stream")
+		}
+		slog.Warn("TWILIO_INSECURE is true, any media stream is answered")
+	}
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.Default()
+	router.GET("/media", srv.handleMediaStream)
+	srv.http = &http.Server{Addr: addr, Handler: router}
+	return srv, nil
+}
+
+func (srv *Server) Name() string {
+	return "twilio"
+}
+
+// Start serves the media streams until ctx is done
+func (srv *Server) Start(ctx context.Context) error {
+	// The calls end when ctx is done
+	srv.http.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		srv.shutdown()
+	}()
+	go srv.bot.SweepRecordings(ctx)
+
+	slog.Info(fmt.Sprintf("Starting twilio media streams server on %s", srv.http.Addr))
+	if err := srv.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop shuts down the server and waits until the calls in progress end
+func (srv *Server) Stop() error {
+	err := srv.shutdown()
+	srv.calls.Wait()
+	return err
+}
+
+func (srv *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.http.Shutdown(ctx)
+}
+
+// handleMediaStream answers the call of the media stream until the caller hangs up
+func (srv *Server) handleMediaStream(c *gin.Context) {
+	if !srv.authorized(c.Request) {
+		slog.Warn(fmt.Sprintf("unauthorized media stream from %s", c.ClientIP()))
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	conn, err := srv.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to upgrade to websocket: %v", err))
+		return
+	}
+	srv.calls.Add(1)
+	defer srv.calls.Done()
+	defer conn.Close()
+
+	ctx := c.Request.Context()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	t := &stream{conn: conn, keys: make(chan byte, 32)}
+	conn.SetReadDeadline(time.Now().Add(startTimeout))
+	start, err := t.waitStart()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("media stream closed before starting: %v", err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// The language is set with <Parameter name="language"> in the TwiML, otherwise it is detected
+	srv.bot.Handle(ctx, start.CallSid, start.CustomParameters["language"], t)
+}
+
+// authorized checks that the media stream is signed by Twilio with TWILIO_AUTH_TOKEN, or that it has the
+// TWILIO_STREAM_TOKEN in the token parameter of the URL. Any media stream is authorized when TWILIO_INSECURE is true.
+func (srv *Server) authorized(r *http.Request) bool {
+	if srv.authToken == "" && srv.streamToken == "" {
+		return true
+	}
+	if signature := r.Header.Get("X-Twilio-Signature"); srv.authToken != "" && signature != "" {
+		// Twilio signs the URL of the stream as set in the TwiML, the proxy in front can change the host
+		publicUrl := srv.publicUrl
+		if publicUrl == "" {
+			publicUrl = "wss://" + r.Host
+		}
+		return hmac.Equal([]byte(signature), []byte(sign(srv.authToken, publicUrl+r.URL.RequestURI())))
+	}
+	token := r.URL.Query().Get("token")
+	return srv.streamToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(srv.streamToken)) == 1
+}
+
+// sign returns the signature of the URL of a request of Twilio without parameters
+func sign(authToken string, url string) string {
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(url))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
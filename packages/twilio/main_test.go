@@ -0,0 +1,124 @@
+package twilio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+)
+
+// silentTTS says nothing, the channel only needs an engine to start
+type silentTTS struct{}
+
+func (silentTTS) Synthesize(ctx context.Context, text string, language string) ([]byte, int, error) {
+	return nil, 8000, nil
+}
+
+func newTestServer(t *testing.T, env map[string]string) (*Server, error) {
+	for _, name := range []string{"TWILIO_AUTH_TOKEN", "TWILIO_PUBLIC_URL", "TWILIO_STREAM_TOKEN", "TWILIO_INSECURE"} {
+		t.Setenv(name, env[name])
+	}
+	return New(channels.Engines{TTS: silentTTS{}})
+}
+
+func TestNewNeedsToken(t *testing.T) {
+	if _, err := newTestServer(t, nil); err == nil {
+		t.Fatal("the channel started without token")
+	}
+	if _, err := newTestServer(t, map[string]string{"TWILIO_INSECURE": "true"}); err != nil {
+		t.Errorf("the channel didn't start with TWILIO_INSECURE=true: %v", err)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		url        string
+		signature  string
+		authorized bool
+	}{
+		{
+			name:       "twilio signature",
+			env:        map[string]string{"TWILIO_AUTH_TOKEN": "secret"},
+			url:        "/media",
+			signature:  sign("secret", "wss://bot.example.com/media"),
+			authorized: true,
+		},
+		{
+			name:       "twilio signature of the public url",
+			env:        map[string]string{"TWILIO_AUTH_TOKEN": "secret", "TWILIO_PUBLIC_URL": "wss://calls.example.com/"},
+			url:        "/media?tenant=1",
+			signature:  sign("secret", "wss://calls.example.com/media?tenant=1"),
+			authorized: true,
+		},
+		{
+			name:      "signature of another url",
+			env:       map[string]string{"TWILIO_AUTH_TOKEN": "secret"},
+			url:       "/media",
+			signature: sign("secret", "wss://evil.example.com/media"),
+		},
+		{
+			name:      "signature of another account",
+			env:       map[string]string{"TWILIO_AUTH_TOKEN": "secret"},
+			url:       "/media",
+			signature: sign("other", "wss://bot.example.com/media"),
+		},
+		{
+			name: "no signature",
+			env:  map[string]string{"TWILIO_AUTH_TOKEN": "secret"},
+			url:  "/media",
+		},
+		{
+			name:       "stream token",
+			env:        map[string]string{"TWILIO_STREAM_TOKEN": "token"},
+			url:        "/media?token=token",
+			authorized: true,
+		},
+		{
+			name: "wrong stream token",
+			env:  map[string]string{"TWILIO_STREAM_TOKEN": "token"},
+			url:  "/media?token=token2",
+		},
+		{
+			name: "token without stream token",
+			env:  map[string]string{"TWILIO_AUTH_TOKEN": "secret"},
+			url:  "/media?token=",
+		},
+		{
+			name:       "insecure",
+			env:        map[string]string{"TWILIO_INSECURE": "true"},
+			url:        "/media",
+			authorized: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := newTestServer(t, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Host = "bot.example.com"
+			if tt.signature != "" {
+				req.Header.Set("X-Twilio-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			srv.http.Handler.ServeHTTP(w, req)
+			// An authorized request fails later, as it is not a WebSocket
+			if authorized := w.Code != http.StatusUnauthorized; authorized != tt.authorized {
+				t.Errorf("got %d, want authorized %v", w.Code, tt.authorized)
+			}
+		})
+	}
+}
+
+// TestSign checks the signature with the example of the Twilio docs
+func TestSign(t *testing.T) {
+	got := sign("12345", "https://mycompany.com/myapp.php?foo=1&bar=2CallSidCA1234567890ABCDECaller+12349013030Digits1234From+12349013030To+18005551212")
+	if want := "0/KCTR6DLpKmkAf8muzZqo1nDgQ="; got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package twilio
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+// event is a message of the media stream. Event is connected, start, media, mark, dtmf or stop.
+type event struct {
+	Event     string      `json:"event"`
+	StreamSid string      `json:"streamSid,omitempty"`
+	Start     *startEvent `json:"start,omitempty"`
+	Media     *mediaEvent `json:"media,omitempty"`
+	Dtmf      *dtmfEvent  `json:"dtmf,omitempty"`
+}
+
+type startEvent struct {
+	StreamSid        string            `json:"streamSid"`
+	CallSid          string            `json:"callSid"`
+	Tracks           []string          `json:"tracks"`
+	CustomParameters map[string]string `json:"customParameters"`
+}
+
+// mediaEvent carries the audio, g711 u-law at 8kHz base64 encoded
+type mediaEvent struct {
+	Track   string `json:"track,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// dtmfEvent is a key pressed by the caller
+type dtmfEvent struct {
+	Track string `json:"track"`
+	Digit string `json:"digit"`
+}
+
+// stream carries the audio of a call through the media stream WebSocket
+type stream struct {
+	conn      *websocket.Conn
+	streamSid string
+	callSid   string
+	keys      chan byte
+	// writeMu serializes the writes, as the audio and the hangup are sent from different goroutines
+	writeMu sync.Mutex
+}
+
+// waitStart reads the events until the stream starts
+func (t *stream) waitStart() (*startEvent, error) {
+	for {
+		var e event
+		if err := t.conn.ReadJSON(&e); err != nil {
+			return nil, err
+		}
+		switch e.Event {
+		case "connected":
+		case "start":
+			if e.Start == nil {
+				return nil, fmt.Errorf("start event without its details")
+			}
+			t.streamSid, t.callSid = e.Start.StreamSid, e.Start.CallSid
+			if t.streamSid == "" {
+				t.streamSid = e.StreamSid
+			}
+			slog.Info(fmt.Sprintf("Media stream %s started", t.streamSid), "callId", t.callSid)
+			return e.Start, nil
+		case "stop":
+			return nil, io.EOF
+		}
+	}
+}
+
+// ReadAudio returns the next audio of the caller decoded to PCM
+func (t *stream) ReadAudio() ([]byte, error) {
+	for {
+		var e event
+		if err := t.conn.ReadJSON(&e); err != nil {
+			// The caller hung up when the WebSocket is closed, cleanly or not
+			slog.Debug(fmt.Sprintf("media stream closed: %v", err), "callId", t.callSid)
+			return nil, io.EOF
+		}
+		switch e.Event {
+		case "media":
+			if e.Media == nil || e.Media.Track == "outbound" {
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(e.Media.Payload)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("invalid media payload: %v", err), "callId", t.callSid)
+				continue
+			}
+			return voice.DecodeG711(data, "ulaw"), nil
+		case "dtmf":
+			if e.Dtmf == nil || e.Dtmf.Digit == "" {
+				continue
+			}
+			select {
+			case t.keys <- e.Dtmf.Digit[0]:
+			default:
+				slog.Warn("Too many keys pressed, discarding them", "callId", t.callSid)
+			}
+		case "stop":
+			return nil, io.EOF
+		}
+	}
+}
+
+// Keys returns the keys pressed by the caller
+func (t *stream) Keys() <-chan byte {
+	return t.keys
+}
+
+// WriteAudio sends the audio to the caller encoded to g711 u-law
+func (t *stream) WriteAudio(frame []byte) error {
+	e := event{
+		Event:     "media",
+		StreamSid: t.streamSid,
+		Media:     &mediaEvent{Payload: base64.StdEncoding.EncodeToString(voice.EncodeUlaw(frame))},
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.conn.WriteJSON(e)
+}
+
+// Hangup closes the media stream. Twilio goes on with the next verb of the TwiML, add <Hangup/> after
+// <Connect> to end the call.
+func (t *stream) Hangup() error {
+	t.writeMu.Lock()
+	t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+	t.writeMu.Unlock()
+	return t.conn.Close()
+}
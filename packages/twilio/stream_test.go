@@ -0,0 +1,154 @@
+package twilio
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/voice"
+	"github.com/gorilla/websocket"
+)
+
+// connect returns the stream of the server side and the WebSocket of the media stream
+func connect(t *testing.T) (*stream, *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return &stream{conn: conn, keys: make(chan byte, 32)}, client
+}
+
+func send(t *testing.T, client *websocket.Conn, events ...string) {
+	t.Helper()
+	for _, e := range events {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	s, client := connect(t)
+	send(t, client,
+		`{"event":"connected","protocol":"Call","version":"1.0.0"}`,
+		`{"event":"start","sequenceNumber":"1","streamSid":"MZ1","start":{"accountSid":"AC1","callSid":"CA1","tracks":["inbound"],"customParameters":{"language":"es"},"mediaFormat":{"encoding":"audio/x-mulaw","sampleRate":8000,"channels":1}}}`,
+	)
+	start, err := s.waitStart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.CallSid != "CA1" || start.CustomParameters["language"] != "es" || s.streamSid != "MZ1" || s.callSid != "CA1" {
+		t.Errorf("got start %+v of stream %s", start, s.streamSid)
+	}
+
+	// A stream stopped before starting is not answered
+	s, client = connect(t)
+	send(t, client, `{"event":"connected"}`, `{"event":"stop","streamSid":"MZ2","stop":{"callSid":"CA2"}}`)
+	if _, err := s.waitStart(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v for a stream stopped before starting, want EOF", err)
+	}
+	s, client = connect(t)
+	send(t, client, `{"event":"start","streamSid":"MZ3"}`)
+	if _, err := s.waitStart(); err == nil {
+		t.Error("start event without details accepted")
+	}
+}
+
+func TestReadAudio(t *testing.T) {
+	s, client := connect(t)
+	pcm := make([]byte, voice.FrameSize)
+	for i := 0; i < len(pcm); i += 2 {
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(i*50-8000)))
+	}
+	payload := base64.StdEncoding.EncodeToString(voice.EncodeUlaw(pcm))
+	send(t, client,
+		`{"event":"media","streamSid":"MZ1","media":{"track":"outbound","payload":"`+base64.StdEncoding.EncodeToString([]byte{1, 2})+`"}}`,
+		`{"event":"media","streamSid":"MZ1","media":{"track":"inbound","payload":"not base64!"}}`,
+		`{"event":"mark","streamSid":"MZ1","mark":{"name":"m1"}}`,
+		`{"event":"dtmf","streamSid":"MZ1","dtmf":{"track":"inbound_track","digit":"5"}}`,
+		`{"event":"media","streamSid":"MZ1","media":{"track":"inbound","chunk":"2","timestamp":"20","payload":"`+payload+`"}}`,
+		`{"event":"stop","streamSid":"MZ1","stop":{"callSid":"CA1"}}`,
+	)
+
+	// The outbound audio, the invalid payloads and the other events are skipped
+	frame, err := s.ReadAudio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := voice.DecodeG711(voice.EncodeUlaw(pcm), "ulaw"); string(frame) != string(want) {
+		t.Errorf("got %d bytes of audio, want the inbound audio decoded", len(frame))
+	}
+	select {
+	case key := <-s.Keys():
+		if key != '5' {
+			t.Errorf("got key %c, want 5", key)
+		}
+	default:
+		t.Error("key pressed not received")
+	}
+	if _, err := s.ReadAudio(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v after the stop event, want EOF", err)
+	}
+
+	// The caller hung up when the WebSocket is closed
+	s, client = connect(t)
+	client.Close()
+	if _, err := s.ReadAudio(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v after the WebSocket closed, want EOF", err)
+	}
+}
+
+func TestWriteAudio(t *testing.T) {
+	s, client := connect(t)
+	s.streamSid = "MZ1"
+	pcm := make([]byte, voice.FrameSize)
+	for i := 0; i < len(pcm); i += 2 {
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(8000-i*50)))
+	}
+	if err := s.WriteAudio(pcm); err != nil {
+		t.Fatal(err)
+	}
+
+	var e event
+	if err := client.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Event != "media" || e.StreamSid != "MZ1" || e.Media == nil {
+		t.Fatalf("got event %+v, want the media of the stream", e)
+	}
+	ulaw, err := base64.StdEncoding.DecodeString(e.Media.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ulaw) != string(voice.EncodeUlaw(pcm)) {
+		t.Error("audio not sent as base64 u-law")
+	}
+
+	if err := s.Hangup(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got %v after the hangup, want a normal close", err)
+	}
+}
@@ -0,0 +1,166 @@
+package vad
+
+import (
+	"math"
+	"time"
+)
+
+// Event is the change of state detected after processing a frame
+type Event int
+
+const (
+	// None means that the state didn't change
+	None Event = iota
+	// SpeechStart means that the caller started speaking
+	SpeechStart
+	// SpeechEnd means that the caller stopped speaking, the utterance is complete
+	SpeechEnd
+)
+
+const (
+	// Speed of adaptation of the noise floor when the noise decreases and increases.
+	// It goes down fast so a loud start of the call doesn't leave the detector deaf,
+	// and up slowly so the speech itself doesn't become noise.
+	noiseFloorFall = 0.1
+	noiseFloorRise = 0.01
+)
+
+// Detector finds the start and the end of the speech in a stream of audio frames.
+// It compares the energy of each frame with an adaptive estimate of the noise floor of the line,
+// and uses the zero crossing rate to discard broadband noise.
+type Detector struct {
+	cfg        Config
+	noiseFloor float64
+
+	speaking  bool
+	candidate time.Duration
+	silence   time.Duration
+	speech    time.Duration
+
+	// preRoll keeps the latest frames while the caller is silent
+	preRoll      [][]byte
+	preRollAudio time.Duration
+	utterance    []byte
+}
+
+// New creates a detector
+func New(cfg Config) *Detector {
+	return &Detector{cfg: cfg}
+}
+
+// Process consumes a frame of PCM 16bit signed linear mono (little-endian) audio and returns the detected event
+func (d *Detector) Process(frame []byte) Event {
+	duration := d.frameDuration(frame)
+	energy := Energy(frame)
+	if d.noiseFloor == 0 {
+		d.noiseFloor = math.Max(energy, 1)
+	}
+
+	if !d.speaking {
+		if d.isVoice(frame, energy, d.cfg.StartThreshold) {
+			d.candidate += duration
+		} else {
+			d.candidate = 0
+			d.adaptNoiseFloor(energy)
+		}
+		d.keepPreRoll(frame, duration)
+		if d.candidate < d.cfg.MinSpeech || d.candidate == 0 {
+			return None
+		}
+		d.speaking = true
+		d.speech = d.candidate
+		d.silence = 0
+		d.utterance = d.utterance[:0]
+		for _, f := range d.preRoll {
+			d.utterance = append(d.utterance, f...)
+		}
+		d.preRoll = nil
+		d.preRollAudio = 0
+		return SpeechStart
+	}
+
+	d.utterance = append(d.utterance, frame...)
+	d.speech += duration
+	if d.isVoice(frame, energy, d.cfg.EndThreshold) {
+		d.silence = 0
+	} else {
+		d.silence += duration
+		d.adaptNoiseFloor(energy)
+	}
+	if d.silence >= d.cfg.Hangover || (d.cfg.MaxSpeech > 0 && d.speech >= d.cfg.MaxSpeech) {
+		d.speaking = false
+		d.candidate = 0
+		return SpeechEnd
+	}
+	return None
+}
+
+// Utterance returns the audio of the last speech, including the pre-roll
+func (d *Detector) Utterance() []byte {
+	return append([]byte(nil), d.utterance...)
+}
+
+// Speaking tells if the caller is speaking
+func (d *Detector) Speaking() bool {
+	return d.speaking
+}
+
+// Silence returns how long the caller has been silent since the speech started
+func (d *Detector) Silence() time.Duration {
+	return d.silence
+}
+
+// EndSpeech ends the current speech before the hangover is over, e.g. when the transcription
+// already shows a complete sentence. The utterance is kept.
+func (d *Detector) EndSpeech() {
+	d.speaking = false
+	d.candidate = 0
+}
+
+// NoiseFloor returns the current estimate of the RMS of the background noise
+func (d *Detector) NoiseFloor() float64 {
+	return d.noiseFloor
+}
+
+// Reset forgets the current speech, keeping the noise floor of the line
+func (d *Detector) Reset() {
+	d.speaking = false
+	d.candidate = 0
+	d.silence = 0
+	d.speech = 0
+	d.preRoll = nil
+	d.preRollAudio = 0
+	d.utterance = nil
+}
+
+// isVoice tells if the frame is over the noise floor by the given dB and doesn't look like broadband noise
+func (d *Detector) isVoice(frame []byte, energy float64, db float64) bool {
+	return energy >= d.threshold(db) && ZeroCrossingRate(frame) <= d.cfg.MaxZeroCrossingRate
+}
+
+// threshold returns the energy a frame needs to be over the noise floor by the given dB
+func (d *Detector) threshold(db float64) float64 {
+	return math.Max(d.noiseFloor*dbToRatio(db), d.cfg.MinEnergy)
+}
+
+func (d *Detector) adaptNoiseFloor(energy float64) {
+	rate := noiseFloorRise
+	if energy < d.noiseFloor {
+		rate = noiseFloorFall
+	}
+	d.noiseFloor = math.Max(d.noiseFloor+(energy-d.noiseFloor)*rate, 1)
+}
+
+// keepPreRoll stores the frame, dropping the oldest ones not needed for the pre-roll and the minimum speech
+func (d *Detector) keepPreRoll(frame []byte, duration time.Duration) {
+	d.preRoll = append(d.preRoll, append([]byte(nil), frame...))
+	d.preRollAudio += duration
+	for len(d.preRoll) > 1 && d.preRollAudio-d.frameDuration(d.preRoll[0]) >= d.cfg.PreRoll+d.candidate {
+		d.preRollAudio -= d.frameDuration(d.preRoll[0])
+		d.preRoll = d.preRoll[1:]
+	}
+}
+
+func (d *Detector) frameDuration(frame []byte) time.Duration {
+	return time.Duration(len(frame)/2) * time.Second / time.Duration(d.cfg.SampleRate)
+}
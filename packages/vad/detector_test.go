@@ -0,0 +1,342 @@
+package vad
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+const frameDuration = 20 * time.Millisecond
+
+// signal builds PCM 16bit signed linear audio at 8kHz
+type signal struct {
+	samples []float64
+	rng     *rand.Rand
+}
+
+func newSignal() *signal {
+	return &signal{rng: rand.New(rand.NewSource(8000))}
+}
+
+// tone adds a sine wave of the given frequency and amplitude over white noise of the given RMS
+func (s *signal) tone(frequency float64, amplitude float64, noise float64, d time.Duration) *signal {
+	for i := 0; i < samplesOf(d); i++ {
+		s.samples = append(s.samples, amplitude*math.Sin(2*math.Pi*frequency*float64(i)/8000)+s.rng.NormFloat64()*noise)
+	}
+	return s
+}
+
+// noise adds white noise of the given RMS
+func (s *signal) noise(rms float64, d time.Duration) *signal {
+	return s.tone(0, 0, rms, d)
+}
+
+func (s *signal) pcm() []byte {
+	pcm := make([]byte, 2*len(s.samples))
+	for i, v := range s.samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(math.Max(-32768, math.Min(32767, math.Round(v))))))
+	}
+	return pcm
+}
+
+func samplesOf(d time.Duration) int {
+	return int(d * 8000 / time.Second)
+}
+
+// event is an event of the detector and the time of the audio when it happened
+type event struct {
+	event Event
+	at    time.Duration
+	// utterance is the audio returned by the detector at the event
+	utterance []byte
+}
+
+// detect processes the audio in frames of 20ms and returns the events
+func detect(d *Detector, pcm []byte) []event {
+	var events []event
+	size := 2 * samplesOf(frameDuration)
+	for i := 0; i+size <= len(pcm); i += size {
+		if e := d.Process(pcm[i : i+size]); e != None {
+			at := time.Duration(i+size) * time.Second / (2 * 8000)
+			events = append(events, event{event: e, at: at, utterance: d.Utterance()})
+		}
+	}
+	return events
+}
+
+// expectEvents checks the events happened in order, each one within tolerance of the expected time
+func expectEvents(t *testing.T, got []event, want []event, tolerance time.Duration) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d events %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i].event != want[i].event || got[i].at < want[i].at-tolerance || got[i].at > want[i].at+tolerance {
+			t.Errorf("event %d is %v at %v, want %v at %v", i, got[i].event, got[i].at, want[i].event, want[i].at)
+		}
+	}
+}
+
+func TestToneOverNoise(t *testing.T) {
+	cfg := DefaultConfig()
+	pcm := newSignal().
+		noise(50, time.Second).
+		tone(300, 3000, 50, time.Second).
+		noise(50, 2*time.Second).
+		pcm()
+
+	events := detect(New(cfg), pcm)
+	expectEvents(t, events, []event{
+		{event: SpeechStart, at: time.Second + cfg.MinSpeech},
+		{event: SpeechEnd, at: 2*time.Second + cfg.Hangover},
+	}, 2*frameDuration)
+}
+
+func TestWhiteNoiseIsRejected(t *testing.T) {
+	pcm := newSignal().
+		noise(50, time.Second).
+		noise(5000, 2*time.Second).
+		noise(50, time.Second).
+		pcm()
+	if zcr := ZeroCrossingRate(pcm[:2*samplesOf(frameDuration)]); zcr <= DefaultConfig().MaxZeroCrossingRate {
+		t.Fatalf("zero crossing rate of white noise is %f, the test is not valid", zcr)
+	}
+
+	if events := detect(New(DefaultConfig()), pcm); len(events) != 0 {
+		t.Errorf("got events %v for white noise", events)
+	}
+}
+
+func TestHangover(t *testing.T) {
+	cfg := DefaultConfig()
+	tests := []struct {
+		name string
+		gap  time.Duration
+		want []event
+	}{
+		{
+			name: "pause shorter than the hangover",
+			gap:  cfg.Hangover / 2,
+			want: []event{
+				{event: SpeechStart, at: 500*time.Millisecond + cfg.MinSpeech},
+				{event: SpeechEnd, at: 500*time.Millisecond + 2*time.Second + cfg.Hangover/2 + cfg.Hangover},
+			},
+		},
+		{
+			name: "pause longer than the hangover",
+			gap:  cfg.Hangover * 3 / 2,
+			want: []event{
+				{event: SpeechStart, at: 500*time.Millisecond + cfg.MinSpeech},
+				{event: SpeechEnd, at: 500*time.Millisecond + time.Second + cfg.Hangover},
+				{event: SpeechStart, at: 500*time.Millisecond + time.Second + cfg.Hangover*3/2 + cfg.MinSpeech},
+				{event: SpeechEnd, at: 500*time.Millisecond + 2*time.Second + cfg.Hangover*3/2 + cfg.Hangover},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcm := newSignal().
+				noise(50, 500*time.Millisecond).
+				tone(300, 3000, 50, time.Second).
+				noise(50, tt.gap).
+				tone(300, 3000, 50, time.Second).
+				noise(50, 2*cfg.Hangover).
+				pcm()
+			expectEvents(t, detect(New(cfg), pcm), tt.want, 2*frameDuration)
+		})
+	}
+}
+
+func TestPreRoll(t *testing.T) {
+	cfg := DefaultConfig()
+	pcm := newSignal().
+		noise(50, time.Second).
+		tone(300, 3000, 50, time.Second).
+		noise(50, 2*time.Second).
+		pcm()
+
+	events := detect(New(cfg), pcm)
+	if len(events) != 2 {
+		t.Fatalf("got events %v, want the start and the end of the speech", events)
+	}
+
+	// At the start the utterance is the pre-roll and the minimum speech
+	start := events[0].utterance
+	if got, want := len(start), 2*samplesOf(cfg.PreRoll+cfg.MinSpeech); got != want {
+		t.Fatalf("utterance at the start has %d bytes, want %d", got, want)
+	}
+	preRoll := 2 * samplesOf(cfg.PreRoll)
+	if e := Energy(start[:preRoll]); e > 100 {
+		t.Errorf("energy of the pre-roll is %f, want the noise before the speech", e)
+	}
+	if e := Energy(start[preRoll:]); e < 1000 {
+		t.Errorf("energy after the pre-roll is %f, want the speech", e)
+	}
+
+	// At the end the utterance has the pre-roll, the speech and the hangover
+	end := events[1].utterance
+	if got, want := len(end), 2*samplesOf(cfg.PreRoll+time.Second+cfg.Hangover); got != want {
+		t.Errorf("utterance at the end has %d bytes, want %d", got, want)
+	}
+	if string(end[:len(start)]) != string(start) {
+		t.Error("utterance at the end doesn't begin with the pre-roll")
+	}
+}
+
+func TestMaxSpeech(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxSpeech = 2 * time.Second
+	pcm := newSignal().
+		noise(50, 500*time.Millisecond).
+		tone(300, 3000, 50, 5*time.Second).
+		pcm()
+
+	events := detect(New(cfg), pcm)
+	if len(events) < 2 {
+		t.Fatalf("got events %v, want the speech cut", events)
+	}
+	start := 500*time.Millisecond + cfg.MinSpeech
+	expectEvents(t, events[:2], []event{
+		{event: SpeechStart, at: start},
+		{event: SpeechEnd, at: start + cfg.MaxSpeech - cfg.MinSpeech},
+	}, 2*frameDuration)
+	if got := len(events[1].utterance); got > 2*samplesOf(cfg.PreRoll+cfg.MaxSpeech) {
+		t.Errorf("utterance cut at the max speech has %d bytes", got)
+	}
+}
+
+func TestNoiseFloorAdaptsAfterLoudStart(t *testing.T) {
+	cfg := DefaultConfig()
+	d := New(cfg)
+	// The call starts with a loud hum, which becomes the noise floor, and then the line is quiet
+	loud := newSignal().tone(100, 20000, 0, 200*time.Millisecond).pcm()
+	if events := detect(d, loud); len(events) != 0 {
+		t.Fatalf("got events %v for the first frames", events)
+	}
+	if d.NoiseFloor() < 10000 {
+		t.Fatalf("noise floor is %f after a loud start, the test is not valid", d.NoiseFloor())
+	}
+
+	quiet := newSignal().noise(50, 2*time.Second).pcm()
+	detect(d, quiet)
+	if d.NoiseFloor() > 100 {
+		t.Fatalf("noise floor is %f after 2s of quiet line, want it adapted to the noise", d.NoiseFloor())
+	}
+
+	// Normal speech is detected once the floor adapted
+	speech := newSignal().tone(300, 3000, 50, time.Second).noise(50, 2*time.Second).pcm()
+	expectEvents(t, detect(d, speech), []event{
+		{event: SpeechStart, at: cfg.MinSpeech},
+		{event: SpeechEnd, at: time.Second + cfg.Hangover},
+	}, 2*frameDuration)
+
+	// The floor rises slowly, so the speech doesn't become noise
+	if d.NoiseFloor() > 200 {
+		t.Errorf("noise floor is %f after the speech", d.NoiseFloor())
+	}
+}
+
+// TestRecordings runs the detector on recordings of a piano through a phone line, see testdata/README.md
+func TestRecordings(t *testing.T) {
+	cfg := DefaultConfig()
+	tests := []struct {
+		file string
+		want []event
+	}{
+		{
+			file: "testdata/one-phrase-8k.wav",
+			want: []event{
+				{event: SpeechStart, at: time.Second + cfg.MinSpeech},
+				{event: SpeechEnd, at: 2750*time.Millisecond + cfg.Hangover},
+			},
+		},
+		{
+			file: "testdata/two-phrases-8k.wav",
+			want: []event{
+				{event: SpeechStart, at: 500*time.Millisecond + cfg.MinSpeech},
+				{event: SpeechEnd, at: 1250*time.Millisecond + cfg.Hangover},
+				{event: SpeechStart, at: 2750*time.Millisecond + cfg.MinSpeech},
+				{event: SpeechEnd, at: 3700*time.Millisecond + cfg.Hangover},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pcm, sampleRate, err := common.DecodeWav(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sampleRate != 8000 {
+				t.Fatalf("sample rate of the fixture is %d, want 8000", sampleRate)
+			}
+			// The notes fade out, so the end is less precise
+			expectEvents(t, detect(New(cfg), pcm), tt.want, 300*time.Millisecond)
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+		check   func(cfg Config) bool
+	}{
+		{
+			name:  "defaults",
+			check: func(cfg Config) bool { return cfg == DefaultConfig() },
+		},
+		{
+			name: "values set",
+			env: map[string]string{
+				"VAD_START_THRESHOLD_DB":     "12.5",
+				"VAD_END_THRESHOLD_DB":       "4",
+				"VAD_MIN_ENERGY":             "200",
+				"VAD_MAX_ZERO_CROSSING_RATE": "0.3",
+				"VAD_MIN_SPEECH":             "100ms",
+				"VAD_HANGOVER":               "800ms",
+				"VAD_PRE_ROLL":               "0s",
+				"VAD_MAX_SPEECH":             "0",
+			},
+			check: func(cfg Config) bool {
+				return cfg.StartThreshold == 12.5 && cfg.EndThreshold == 4 && cfg.MinEnergy == 200 &&
+					cfg.MaxZeroCrossingRate == 0.3 && cfg.MinSpeech == 100*time.Millisecond &&
+					cfg.Hangover == 800*time.Millisecond && cfg.PreRoll == 0 && cfg.MaxSpeech == 0 &&
+					cfg.SampleRate == 8000
+			},
+		},
+		{name: "invalid threshold", env: map[string]string{"VAD_START_THRESHOLD_DB": "loud"}, wantErr: true},
+		{name: "invalid zero crossing rate", env: map[string]string{"VAD_MAX_ZERO_CROSSING_RATE": "0,3"}, wantErr: true},
+		{name: "duration without unit", env: map[string]string{"VAD_HANGOVER": "800"}, wantErr: true},
+		{name: "invalid duration", env: map[string]string{"VAD_PRE_ROLL": "soon"}, wantErr: true},
+		{
+			name:    "end threshold bigger than start",
+			env:     map[string]string{"VAD_START_THRESHOLD_DB": "6", "VAD_END_THRESHOLD_DB": "10"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"VAD_START_THRESHOLD_DB", "VAD_END_THRESHOLD_DB", "VAD_MIN_ENERGY",
+				"VAD_MAX_ZERO_CROSSING_RATE", "VAD_MIN_SPEECH", "VAD_HANGOVER", "VAD_PRE_ROLL", "VAD_MAX_SPEECH"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg, err := ConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
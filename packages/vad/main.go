@@ -0,0 +1,129 @@
+package vad
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config tunes the voice activity detector
+type Config struct {
+	// SampleRate of the PCM 16bit signed linear mono (little-endian) audio
+	SampleRate int
+	// StartThreshold is how many dB above the noise floor a frame must be to start the speech
+	StartThreshold float64
+	// EndThreshold is how many dB above the noise floor a frame must be to keep the speech going.
+	// Lower than StartThreshold so the speech is not cut on soft syllables.
+	EndThreshold float64
+	// MinEnergy is the minimum RMS of a speech frame, so very quiet lines never trigger the detector
+	MinEnergy float64
+	// MaxZeroCrossingRate is the maximum rate of sign changes per sample of a voice frame.
+	// Broadband noise like hiss or static crosses zero much more often than voice.
+	MaxZeroCrossingRate float64
+	// MinSpeech is how long the speech must last before it is considered started
+	MinSpeech time.Duration
+	// Hangover is how long the silence must last before the speech is considered ended
+	Hangover time.Duration
+	// PreRoll is the audio before the start of the speech kept in the utterance, so first syllables are not lost
+	PreRoll time.Duration
+	// MaxSpeech ends the speech after this time even if the caller keeps talking. Zero means unlimited.
+	MaxSpeech time.Duration
+}
+
+// DefaultConfig returns the configuration for 8kHz telephony audio
+func DefaultConfig() Config {
+	return Config{
+		SampleRate:          8000,
+		StartThreshold:      10,
+		EndThreshold:        6,
+		MinEnergy:           300,
+		MaxZeroCrossingRate: 0.4,
+		MinSpeech:           200 * time.Millisecond,
+		Hangover:            time.Second,
+		PreRoll:             300 * time.Millisecond,
+		MaxSpeech:           30 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads the configuration from the VAD_* env vars, using DefaultConfig for the ones not set
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	floats := map[string]*float64{
+		"VAD_START_THRESHOLD_DB":     &cfg.StartThreshold,
+		"VAD_END_THRESHOLD_DB":       &cfg.EndThreshold,
+		"VAD_MIN_ENERGY":             &cfg.MinEnergy,
+		"VAD_MAX_ZERO_CROSSING_RATE": &cfg.MaxZeroCrossingRate,
+	}
+	for name, value := range floats {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid value for %s: %s", name, err)
+			}
+			*value = f
+		}
+	}
+	durations := map[string]*time.Duration{
+		"VAD_MIN_SPEECH": &cfg.MinSpeech,
+		"VAD_HANGOVER":   &cfg.Hangover,
+		"VAD_PRE_ROLL":   &cfg.PreRoll,
+		"VAD_MAX_SPEECH": &cfg.MaxSpeech,
+	}
+	for name, value := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid value for %s: %s", name, err)
+			}
+			*value = d
+		}
+	}
+	if cfg.EndThreshold > cfg.StartThreshold {
+		return cfg, fmt.Errorf("VAD_END_THRESHOLD_DB can't be bigger than VAD_START_THRESHOLD_DB")
+	}
+	return cfg, nil
+}
+
+// Energy calculates the RMS of PCM 16bit signed linear (little-endian) samples.
+// This is the amplitude of the audio wave.
+func Energy(frame []byte) float64 {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < samples; i++ {
+		sample := float64(sampleAt(frame, i))
+		sum += sample * sample
+	}
+	return math.Sqrt(sum / float64(samples))
+}
+
+// ZeroCrossingRate calculates the rate of sign changes between consecutive PCM 16bit signed linear samples
+func ZeroCrossingRate(frame []byte) float64 {
+	samples := len(frame) / 2
+	if samples < 2 {
+		return 0
+	}
+	crossings := 0
+	previous := sampleAt(frame, 0)
+	for i := 1; i < samples; i++ {
+		current := sampleAt(frame, i)
+		if (previous >= 0) != (current >= 0) {
+			crossings++
+		}
+		previous = current
+	}
+	return float64(crossings) / float64(samples-1)
+}
+
+func sampleAt(frame []byte, i int) int16 {
+	return int16(uint16(frame[2*i]) | uint16(frame[2*i+1])<<8)
+}
+
+// dbToRatio converts a level difference in dB to an amplitude ratio
+func dbToRatio(db float64) float64 {
+	return math.Pow(10, db/20)
+}
@@ -0,0 +1,148 @@
+package voice
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// goertzelBlock is the number of samples analyzed at once by the tone detector, about 25ms at 8kHz
+	goertzelBlock = 205
+	// minToneEnergy is the minimum mean energy of a block for a tone to be detected, to ignore the line noise
+	minToneEnergy = 100 * 100
+	// minToneShare is the minimum share of the energy of the block carried by each of the two tones, low enough for
+	// the weaker tone of a key with the maximum twist
+	minToneShare = 0.1
+	// minKeyShare is the minimum share of the energy of the block carried by the two tones together
+	minKeyShare = 0.6
+	// maxTwist is the maximum power ratio between the two tones of a key, about 8dB
+	maxTwist = 6.3
+)
+
+var (
+	dtmfRows = [4]float64{697, 770, 852, 941}
+	dtmfCols = [4]float64{1209, 1336, 1477, 1633}
+	dtmfKeys = [4][4]byte{
+		{'1', '2', '3', 'A'},
+		{'4', '5', '6', 'B'},
+		{'7', '8', '9', 'C'},
+		{'*', '0', '#', 'D'},
+	}
+)
+
+// toneDetector detects in-band DTMF, the keys pressed by the caller played as tones in the audio.
+// It uses the Goertzel algorithm to measure the power of the frequencies of the keypad.
+type toneDetector struct {
+	samples []float64
+	// last is the key detected in the previous block, 0 when none
+	last byte
+	// reported is true when the key being pressed was already returned
+	reported bool
+}
+
+// Process consumes a frame of PCM 16bit signed linear (little-endian) audio at 8kHz and returns the key
+// pressed, once per press. A key is detected when its tones last two blocks in a row.
+func (d *toneDetector) Process(frame []byte) (byte, bool) {
+	var pressed byte
+	for i := 0; i+2 <= len(frame); i += 2 {
+		d.samples = append(d.samples, float64(int16(binary.LittleEndian.Uint16(frame[i:]))))
+		if len(d.samples) < goertzelBlock {
+			continue
+		}
+		key := detectKey(d.samples)
+		d.samples = d.samples[:0]
+		if key == 0 || key != d.last {
+			d.last, d.reported = key, false
+			continue
+		}
+		if !d.reported {
+			d.reported = true
+			pressed = key
+		}
+	}
+	return pressed, pressed != 0
+}
+
+// detectKey returns the key whose tones dominate the block, 0 when there is none
+func detectKey(block []float64) byte {
+	var energy float64
+	for _, x := range block {
+		energy += x * x
+	}
+	if energy/float64(len(block)) < minToneEnergy {
+		return 0
+	}
+	row, rowPower, rowSecond := strongest(block, dtmfRows)
+	col, colPower, colSecond := strongest(block, dtmfCols)
+
+	// The power of a tone carrying all the energy of the block is energy * N / 2
+	full := energy * float64(len(block)) / 2
+	if rowPower < minToneShare*full || colPower < minToneShare*full || rowPower+colPower < minKeyShare*full {
+		return 0
+	}
+	if rowPower > maxTwist*colPower || colPower > maxTwist*rowPower {
+		return 0
+	}
+	// The other tones of each group must be much weaker, otherwise it is speech or music
+	if rowSecond*maxTwist > rowPower || colSecond*maxTwist > colPower {
+		return 0
+	}
+	return dtmfKeys[row][col]
+}
+
+// strongest returns the frequency of the group with more power, its power and the power of the next one
+func strongest(block []float64, freqs [4]float64) (int, float64, float64) {
+	best, first, second := 0, 0.0, 0.0
+	for i, f := range freqs {
+		p := goertzel(block, f)
+		switch {
+		case p > first:
+			best, first, second = i, p, first
+		case p > second:
+			second = p
+		}
+	}
+	return best, first, second
+}
+
+// goertzel returns the power of the frequency in the block
+func goertzel(block []float64, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/SampleRate)
+	var s1, s2 float64
+	for _, x := range block {
+		s1, s2 = x+coeff*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+// keypadEntry collects the keys pressed by the caller until # is pressed or no key is pressed for a while
+type keypadEntry struct {
+	digits  strings.Builder
+	lastKey time.Time
+}
+
+// press adds the key to the entry and tells whether the entry is complete
+func (k *keypadEntry) press(key byte) bool {
+	k.lastKey = time.Now()
+	if key == '#' {
+		return true
+	}
+	k.digits.WriteByte(key)
+	return false
+}
+
+// pending tells if the caller started to enter keys
+func (k *keypadEntry) pending() bool {
+	return !k.lastKey.IsZero()
+}
+
+// expired tells if the caller stopped pressing keys for longer than timeout
+func (k *keypadEntry) expired(timeout time.Duration) bool {
+	return k.pending() && time.Since(k.lastKey) >= timeout
+}
+
+func (k *keypadEntry) String() string {
+	return k.digits.String()
+}
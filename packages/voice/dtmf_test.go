@@ -0,0 +1,262 @@
+package voice
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/vad"
+)
+
+// signal synthesizes d of audio at 8kHz as the sum of the tones, each one with its amplitude, and white noise
+// of the given RMS
+type signal struct {
+	freqs []float64
+	amps  []float64
+	noise float64
+}
+
+func (sig signal) pcm(d time.Duration, rnd *rand.Rand) []byte {
+	n := int(d.Seconds() * SampleRate)
+	data := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		x := sig.noise * rnd.NormFloat64()
+		for j, f := range sig.freqs {
+			x += sig.amps[j] * math.Sin(2*math.Pi*f*float64(i)/SampleRate)
+		}
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(int16(max(min(x, math.MaxInt16), math.MinInt16))))
+	}
+	return data
+}
+
+// keyTones returns the tones of the key, with the amplitudes of the row and the column
+func keyTones(key byte, rowAmp float64, colAmp float64) signal {
+	for r, keys := range dtmfKeys {
+		for c, k := range keys {
+			if k == key {
+				return signal{freqs: []float64{dtmfRows[r], dtmfCols[c]}, amps: []float64{rowAmp, colAmp}}
+			}
+		}
+	}
+	panic("not a key")
+}
+
+// detect feeds the audio to a tone detector in frames of 20ms and returns the keys detected
+func detect(audio []byte) string {
+	var d toneDetector
+	var keys []byte
+	for i := 0; i < len(audio); i += FrameSize {
+		if key, ok := d.Process(audio[i:min(i+FrameSize, len(audio))]); ok {
+			keys = append(keys, key)
+		}
+	}
+	return string(keys)
+}
+
+// pressKeys synthesizes the keys pressed for 80ms, each one followed by 60ms of silence or noise
+func pressKeys(keys string, rowAmp float64, colAmp float64, noise float64, rnd *rand.Rand) []byte {
+	var audio []byte
+	for i := 0; i < len(keys); i++ {
+		tones := keyTones(keys[i], rowAmp, colAmp)
+		tones.noise = noise
+		audio = append(audio, tones.pcm(80*time.Millisecond, rnd)...)
+		audio = append(audio, signal{noise: noise}.pcm(60*time.Millisecond, rnd)...)
+	}
+	return audio
+}
+
+func TestToneDetector(t *testing.T) {
+	const allKeys = "123A456B789C*0#D"
+	tests := []struct {
+		name   string
+		keys   string
+		rowAmp float64
+		colAmp float64
+		noise  float64
+		want   string
+	}{
+		{name: "every key", keys: allKeys, rowAmp: 6000, colAmp: 6000, want: allKeys},
+		{name: "same key twice", keys: "55", rowAmp: 6000, colAmp: 6000, want: "55"},
+		{name: "quiet keys", keys: allKeys, rowAmp: 300, colAmp: 300, want: allKeys},
+		{name: "noise", keys: allKeys, rowAmp: 4000, colAmp: 4000, noise: 800, want: allKeys},
+		{name: "loud noise", keys: allKeys, rowAmp: 4000, colAmp: 4000, noise: 1500, want: allKeys},
+		// The column is usually louder than the row, up to 8dB each way is accepted
+		{name: "twist", keys: allKeys, rowAmp: 3000, colAmp: 6000, want: allKeys},
+		{name: "reverse twist", keys: allKeys, rowAmp: 6000, colAmp: 3000, want: allKeys},
+		{name: "maximum twist", keys: allKeys, rowAmp: 2700, colAmp: 6000, want: allKeys},
+		{name: "twist with noise", keys: allKeys, rowAmp: 3000, colAmp: 6000, noise: 600, want: allKeys},
+		{name: "excessive twist", keys: allKeys, rowAmp: 1500, colAmp: 6000},
+		{name: "excessive reverse twist", keys: allKeys, rowAmp: 6000, colAmp: 1500},
+		{name: "below the line noise", keys: allKeys, rowAmp: 50, colAmp: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			if got := detect(pressKeys(tt.keys, tt.rowAmp, tt.colAmp, tt.noise, rnd)); got != tt.want {
+				t.Errorf("got keys %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToneDetectorIgnoresOtherSounds(t *testing.T) {
+	// speech is a vowel: the harmonics of the pitch shaped by the formants
+	var speech signal
+	for f := 120.0; f < 3500; f += 120 {
+		speech.freqs = append(speech.freqs, f)
+		amp := 300.0
+		for _, formant := range []float64{700, 1220, 2600} {
+			amp += 4000 / (1 + math.Pow((f-formant)/100, 2))
+		}
+		speech.amps = append(speech.amps, amp)
+	}
+	tests := []struct {
+		name   string
+		signal signal
+	}{
+		{name: "row tone", signal: signal{freqs: []float64{770}, amps: []float64{8000}}},
+		{name: "column tone", signal: signal{freqs: []float64{1336}, amps: []float64{8000}}},
+		{name: "dial tone", signal: signal{freqs: []float64{350, 440}, amps: []float64{6000, 6000}}},
+		{name: "two rows", signal: signal{freqs: []float64{697, 852}, amps: []float64{6000, 6000}}},
+		{name: "key and another row", signal: signal{freqs: []float64{697, 941, 1209}, amps: []float64{6000, 6000, 6000}}},
+		{name: "speech", signal: speech},
+		{name: "noise", signal: signal{noise: 5000}},
+		{name: "silence", signal: signal{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			if got := detect(tt.signal.pcm(time.Second, rnd)); got != "" {
+				t.Errorf("got keys %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestToneDetectorNeedsTwoBlocks(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// A key held is detected once, even when its tones arrive in odd frames
+	held := keyTones('7', 6000, 6000).pcm(time.Second, rnd)
+	var d toneDetector
+	var keys []byte
+	for i := 0; i < len(held); i += 98 {
+		if key, ok := d.Process(held[i:min(i+98, len(held))]); ok {
+			keys = append(keys, key)
+		}
+	}
+	if string(keys) != "7" {
+		t.Errorf("got keys %q for a key held, want 7", keys)
+	}
+
+	// A blip within one block is not a key
+	blip := keyTones('7', 6000, 6000).pcm(20*time.Millisecond, rnd)
+	if got := detect(append(blip, signal{}.pcm(100*time.Millisecond, rnd)...)); got != "" {
+		t.Errorf("got keys %q for a blip of 20ms, want none", got)
+	}
+}
+
+func TestGoertzel(t *testing.T) {
+	block := make([]float64, goertzelBlock)
+	for i := range block {
+		block[i] = 1000 * math.Sin(2*math.Pi*1209*float64(i)/SampleRate)
+	}
+	// All the energy of the block is in the tone, energy * N / 2
+	full := 1000 * 1000 * goertzelBlock * goertzelBlock / 4.0
+	if p := goertzel(block, 1209); p < 0.9*full || p > 1.1*full {
+		t.Errorf("got power %.0f of the tone, want about %.0f", p, full)
+	}
+	for _, f := range append(dtmfRows[:], dtmfCols[1:]...) {
+		if p := goertzel(block, f); p > 0.05*full {
+			t.Errorf("got power %.0f at %.0fHz for a tone of 1209Hz", p, f)
+		}
+	}
+}
+
+func TestKeypadEntry(t *testing.T) {
+	var k keypadEntry
+	if k.pending() || k.expired(time.Millisecond) {
+		t.Fatal("entry pending before pressing keys")
+	}
+	for _, key := range []byte("12*") {
+		if k.press(key) {
+			t.Fatalf("entry complete after %c", key)
+		}
+	}
+	if !k.pending() || k.expired(time.Minute) {
+		t.Error("entry not pending after pressing keys")
+	}
+	if !k.press('#') {
+		t.Error("entry not complete after #")
+	}
+	if got := k.String(); got != "12*" {
+		t.Errorf("got entry %q, want 12*", got)
+	}
+
+	// The entry is complete when no key is pressed for the timeout
+	k = keypadEntry{}
+	k.press('4')
+	time.Sleep(20 * time.Millisecond)
+	if !k.expired(10 * time.Millisecond) {
+		t.Error("entry not expired after the timeout")
+	}
+	// Each key restarts the timeout
+	k.press('2')
+	if k.expired(10*time.Millisecond) || k.String() != "42" {
+		t.Errorf("got entry %q, expired %v after pressing a key", k.String(), k.expired(10*time.Millisecond))
+	}
+
+	// # alone completes an empty entry
+	k = keypadEntry{}
+	if !k.press('#') || k.String() != "" || !k.pending() {
+		t.Errorf("got entry %q after #, want an empty complete entry", k.String())
+	}
+}
+
+// callerTransport plays the audio of the caller in frames of 20ms, and silence after it
+type callerTransport struct {
+	fakeTransport
+	audio []byte
+}
+
+func (t *callerTransport) ReadAudio() ([]byte, error) {
+	time.Sleep(time.Millisecond)
+	if len(t.audio) == 0 {
+		return make([]byte, FrameSize), nil
+	}
+	frame := t.audio[:min(FrameSize, len(t.audio))]
+	t.audio = t.audio[len(frame):]
+	return frame, nil
+}
+
+func TestInbandKeypad(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		want string
+	}{
+		{name: "entry", keys: "123#", want: "123"},
+		{name: "timeout", keys: "42", want: "42"},
+		{name: "empty entry", keys: "#", want: "#"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &Bot{vadConfig: vad.DefaultConfig(), dtmfInband: true, dtmfTimeout: 100 * time.Millisecond}
+			transport := &callerTransport{audio: pressKeys(tt.keys, 6000, 6000, 0, rand.New(rand.NewSource(1)))}
+			s := newCallSession(context.Background(), bot, "call-1", transport)
+			defer s.cancel()
+
+			go s.processFromCaller()
+			select {
+			case u := <-s.audioDataCh:
+				if u.digits != tt.want || u.audio != nil {
+					t.Errorf("got digits %q and %d bytes of speech, want digits %q", u.digits, len(u.audio), tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("keys not entered")
+			}
+		})
+	}
+}
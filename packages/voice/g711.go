@@ -0,0 +1,90 @@
+package voice
+
+import "encoding/binary"
+
+// ulawToLinear decodes a byte coded in g711 u-law format to a 16-bit signed linear PCM value.
+func ulawToLinear(ulaw byte) int16 {
+	const BIAS = 0x84 // Bias for linear code.
+
+	ulaw ^= 0xFF
+	exponent := (ulaw >> 4) & 0x07
+	mantissa := int16(ulaw & 0x0F)
+	value := ((mantissa << 3) + BIAS) << exponent
+	value -= BIAS
+	if ulaw&0x80 != 0 {
+		return -value
+	}
+	return value
+}
+
+// alawToLinear decodes a byte coded in G.711 A-law format to a 16-bit signed linear PCM value.
+func alawToLinear(alaw byte) int16 {
+	const QUANT_MASK = 0x0F // Quantization field mask.
+	const SEG_MASK = 0x70   // Segment field mask.
+	const SEG_SHIFT = 4     // Left shift for segment number.
+	const BIAS = 0x84       // Bias for linear code.
+
+	alaw ^= 0x55
+
+	segment := (alaw & SEG_MASK) >> SEG_SHIFT
+	mantissa := alaw & QUANT_MASK
+	linear := int16(mantissa<<4) + BIAS
+
+	if segment != 0 {
+		linear += 0x100 << (segment - 1)
+	}
+
+	if alaw&0x80 != 0 {
+		return -linear
+	}
+	return linear
+}
+
+// DecodeG711 decodes G711 audio data, codec ulaw or alaw, to PCM 16bit signed linear (little-endian)
+func DecodeG711(buffer []byte, codec string) []byte {
+	pcm := make([]byte, 2*len(buffer))
+	var sample int16
+	for i, data := range buffer {
+		switch codec {
+		case "ulaw":
+			sample = ulawToLinear(data)
+		case "alaw":
+			sample = alawToLinear(data)
+		}
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+	}
+	return pcm
+}
+
+// linearToUlaw encodes a 16-bit signed linear PCM value to a byte coded in g711 u-law format.
+func linearToUlaw(sample int16) byte {
+	const BIAS = 0x84  // Bias for linear code.
+	const CLIP = 32635 // Maximum value before adding the bias.
+
+	value := int(sample)
+	sign := byte(0)
+	if value < 0 {
+		value = -value
+		sign = 0x80
+	}
+	if value > CLIP {
+		value = CLIP
+	}
+	value += BIAS
+
+	exponent := byte(7)
+	for mask := 0x4000; value&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := byte(value>>(exponent+3)) & 0x0F
+	return ^(sign | exponent<<4 | mantissa)
+}
+
+// EncodeUlaw encodes PCM 16bit signed linear (little-endian) audio data to G711 u-law
+func EncodeUlaw(pcm []byte) []byte {
+	ulaw := make([]byte, len(pcm)/2)
+	for i := range ulaw {
+		ulaw[i] = linearToUlaw(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+	}
+	return ulaw
+}
@@ -0,0 +1,49 @@
+package voice
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestUlawRoundTrip(t *testing.T) {
+	// Every code decodes to a sample which is encoded back to the same code, but the negative zero
+	for code := 0; code < 256; code++ {
+		pcm := DecodeG711([]byte{byte(code)}, "ulaw")
+		got := EncodeUlaw(pcm)[0]
+		if code == 0x7F {
+			// -0 and +0 are the same sample
+			code = 0xFF
+		}
+		if got != byte(code) {
+			t.Errorf("code %#02x decoded to %d and encoded to %#02x", code, int16(binary.LittleEndian.Uint16(pcm)), got)
+		}
+	}
+
+	// Every sample is encoded within the quantization step of its segment
+	for sample := -32768; sample <= 32767; sample += 7 {
+		pcm := make([]byte, 2)
+		binary.LittleEndian.PutUint16(pcm, uint16(int16(sample)))
+		decoded := int(int16(binary.LittleEndian.Uint16(DecodeG711(EncodeUlaw(pcm), "ulaw"))))
+		// The step doubles in each segment, from 8 near zero to 1024 in the loudest one, and the loudest samples clip
+		tolerance := 8 + abs(sample)/16
+		if abs(sample) > 32635 {
+			tolerance = abs(sample) - 32124 + 1024
+		}
+		if abs(decoded-sample) > tolerance {
+			t.Errorf("sample %d decoded as %d", sample, decoded)
+		}
+	}
+}
+
+func TestEncodeUlawIgnoresOddByte(t *testing.T) {
+	if got := EncodeUlaw([]byte{0, 0, 1}); len(got) != 1 {
+		t.Errorf("got %d codes for one sample", len(got))
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
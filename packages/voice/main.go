@@ -0,0 +1,476 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/stt"
+	"github.com/felipem1210/freetalkbot/packages/tts"
+	"github.com/felipem1210/freetalkbot/packages/vad"
+)
+
+const (
+	// SampleRate is the sample rate of the audio exchanged with the transports
+	SampleRate = 8000
+
+	// FrameSize is the number of bytes sent to the transport per frame.
+	// Larger data will be chunked into this size.
+	//
+	// This is based on 8kHz, 20ms, 16-bit signed linear.
+	FrameSize = 320 // 8000Hz * 20ms * 2 bytes
+
+	MaxCallDuration = 2 * time.Minute //  MaxCallDuration is the maximum amount of time to allow a call to be up before it is terminated.
+
+	// defaultDtmfTimeout is how long the caller can wait between keys before the keys entered are sent
+	defaultDtmfTimeout = 3 * time.Second
+)
+
+// Transport carries the audio of a call between the caller and the bot, like Asterisk AudioSocket or a
+// media stream WebSocket. The audio is PCM 16bit signed linear (little-endian), mono, at 8kHz.
+type Transport interface {
+	// ReadAudio returns the next frame of audio from the caller, io.EOF when the caller hung up
+	ReadAudio() ([]byte, error)
+	// WriteAudio sends a frame of audio, up to FrameSize bytes, to the caller
+	WriteAudio(frame []byte) error
+	// Hangup ends the call
+	Hangup() error
+}
+
+// KeypadTransport is implemented by the transports receiving the keys pressed by the caller out of band,
+// like the DTMF messages of AudioSocket
+type KeypadTransport interface {
+	// Keys returns the keys pressed by the caller, received while the audio is read
+	Keys() <-chan byte
+}
+
+// ClipTransport is implemented by the transports playing the audio as whole clips instead of a stream of
+// frames, like the playAudio messages of mod_audio_fork. WriteAudio is not used with them.
+type ClipTransport interface {
+	// PlayClip starts playing the audio to the caller, without waiting until it ends
+	PlayClip(pcm []byte) error
+	// StopClips stops the audio being played, when the caller interrupts it
+	StopClips() error
+}
+
+// Bot answers the calls of a voice channel: it detects the speech of the caller, transcribes it,
+// sends it to the assistant and speaks the responses
+type Bot struct {
+	sttEngine stt.STT
+	// sttStreamer is set when the speech is streamed to the STT tool while the caller speaks
+	sttStreamer stt.Streamer
+	ttsEngine   tts.TTS
+	vadConfig   vad.Config
+	// dtmfInband enables the detection of the keys pressed by the caller in the audio
+	dtmfInband  bool
+	dtmfTimeout time.Duration
+	// idleTimeout is how long the caller can stay silent before being reprompted, 0 disables the reprompts
+	idleTimeout  time.Duration
+	maxReprompts int
+	// thinkingDelay is how long the answer can take before the thinking cue is played
+	thinkingDelay time.Duration
+	thinkingLoop  bool
+	// cues keeps the audio of the thinking cues
+	cues sync.Map
+	// recordingsDir is where the calls are recorded, they are not recorded when it is empty
+	recordingsDir string
+	recordAudio   bool
+	// retention is how long the recordings are kept, 0 keeps them forever
+	retention time.Duration
+}
+
+// NewBot creates the bot with the shared engines, the TTS engine is mandatory
+func NewBot(engines channels.Engines) (*Bot, error) {
+	if engines.TTS == nil {
+		return nil, fmt.Errorf("voice channels need a TTS engine")
+	}
+	b := &Bot{sttEngine: engines.STT, ttsEngine: engines.TTS}
+	// Stream the audio while the user speaks when the STT tool supports it
+	if streamer, ok := engines.STT.(stt.Streamer); ok && os.Getenv("STT_STREAMING") == "true" {
+		b.sttStreamer = streamer
+	}
+
+	var err error
+	b.vadConfig, err = vad.ConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("vad failure: %w", err)
+	}
+
+	b.dtmfTimeout = defaultDtmfTimeout
+	if v := os.Getenv("DTMF_TIMEOUT"); v != "" {
+		b.dtmfTimeout, err = time.ParseDuration(v)
+		if err != nil || b.dtmfTimeout <= 0 {
+			return nil, fmt.Errorf("invalid value %q for DTMF_TIMEOUT", v)
+		}
+	}
+	if v := os.Getenv("DTMF_INBAND"); v != "" {
+		b.dtmfInband, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for DTMF_INBAND", v)
+		}
+	}
+
+	b.idleTimeout = defaultIdleTimeout
+	if v := os.Getenv("VOICE_IDLE_TIMEOUT"); v != "" {
+		b.idleTimeout, err = time.ParseDuration(v)
+		if err != nil || b.idleTimeout < 0 {
+			return nil, fmt.Errorf("invalid value %q for VOICE_IDLE_TIMEOUT", v)
+		}
+	}
+	b.maxReprompts = defaultMaxReprompts
+	if v := os.Getenv("VOICE_MAX_REPROMPTS"); v != "" {
+		b.maxReprompts, err = strconv.Atoi(v)
+		if err != nil || b.maxReprompts < 0 {
+			return nil, fmt.Errorf("invalid value %q for VOICE_MAX_REPROMPTS", v)
+		}
+	}
+
+	b.thinkingDelay = defaultThinkingDelay
+	if v := os.Getenv("VOICE_THINKING_DELAY"); v != "" {
+		b.thinkingDelay, err = time.ParseDuration(v)
+		if err != nil || b.thinkingDelay < 0 {
+			return nil, fmt.Errorf("invalid value %q for VOICE_THINKING_DELAY", v)
+		}
+	}
+	b.thinkingLoop = true
+	if v := os.Getenv("VOICE_THINKING_LOOP"); v != "" {
+		b.thinkingLoop, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for VOICE_THINKING_LOOP", v)
+		}
+	}
+
+	if b.recordingsDir = os.Getenv("VOICE_RECORDINGS_DIR"); b.recordingsDir != "" {
+		if err := os.MkdirAll(b.recordingsDir, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create recordings directory: %w", err)
+		}
+		b.recordAudio = true
+		if v := os.Getenv("VOICE_RECORD_AUDIO"); v != "" {
+			b.recordAudio, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for VOICE_RECORD_AUDIO", v)
+			}
+		}
+		if v := os.Getenv("VOICE_RECORDINGS_RETENTION"); v != "" {
+			b.retention, err = time.ParseDuration(v)
+			if err != nil || b.retention < 0 {
+				return nil, fmt.Errorf("invalid value %q for VOICE_RECORDINGS_RETENTION", v)
+			}
+		}
+	}
+	return b, nil
+}
+
+// SweepRecordings removes the expired recordings until ctx is done, the voice channels run it while they are
+// started. The channels sharing the recordings directory share the sweep too: only the first one sweeps it and the
+// others return at once.
+func (b *Bot) SweepRecordings(ctx context.Context) {
+	if b.recordingsDir == "" || b.retention == 0 {
+		return
+	}
+	dir := filepath.Clean(b.recordingsDir)
+	if _, running := sweeping.LoadOrStore(dir, true); running {
+		return
+	}
+	defer sweeping.Delete(dir)
+	sweepRecordings(ctx, dir, b.retention)
+}
+
+// Handle processes a call until the caller hangs up, ctx is done or MaxCallDuration is reached.
+// language is the language of the caller when it is known in advance, otherwise it is detected.
+func (b *Bot) Handle(pCtx context.Context, id string, language string, t Transport) {
+	var transcription string
+	var err error
+
+	s := newCallSession(pCtx, b, id, t)
+	s.language = language
+	defer s.cancel()
+	defer s.saveRecording()
+	defer s.stopSpeaking()
+	slog.Info("Begin call process", "callId", s.ID())
+
+	if b.sttStreamer != nil {
+		s.openStream()
+		if s.stream != nil {
+			defer s.stream.Close()
+		}
+	}
+
+	s.playingAudioCh <- false
+	s.greet()
+
+	// Configure the call timer
+	callTimer := time.NewTimer(MaxCallDuration)
+	defer callTimer.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			slog.Info("Call context done", "callId", s.ID())
+			s.hangup()
+			return
+		case <-callTimer.C:
+			slog.Info("Max call duration reached, sending hangup signal", "callId", s.ID())
+			s.hangup()
+			s.cancel()
+			return
+		default:
+			// Start listening for user speech
+			slog.Debug("receiving audio", "callId", s.ID())
+			go s.processFromCaller()
+
+			// Getting audio data from the user
+			u, ok := s.waitUtterance()
+			if !ok {
+				continue
+			}
+			s.audioData = u.audio
+			slog.Debug("user stopped speaking", "callId", s.ID())
+			start := time.Now()
+			// The caller hears the thinking cue until the first sentence of the answer is ready
+			stopThinking := s.think()
+			record := &turn{Time: start, Digits: u.digits}
+			s.recorder.addTurn(record)
+
+			switch {
+			case u.digits != "":
+				transcription = u.digits
+			case u.transcribed:
+				transcription = u.transcription
+			default:
+				transcription, err = b.sttEngine.Transcribe(s.ctx, stt.Audio{PCM: s.audioData, SampleRate: SampleRate}, s.language)
+				s.recorder.update(func() { record.TranscriptionMs = time.Since(start).Milliseconds() })
+			}
+
+			if err != nil {
+				slog.Error(fmt.Sprintf("failed to transcribe audio: %v", err), "callId", s.ID())
+				s.recorder.update(func() { record.Error = fmt.Sprintf("failed to transcribe audio: %v", err) })
+				stopThinking()
+				return
+			} else {
+				slog.Debug(fmt.Sprintf("transcription generated: %s", transcription), "callId", s.ID())
+			}
+
+			if s.language == "" && u.digits == "" {
+				s.language = common.DetectLanguage(transcription)
+				slog.Debug(fmt.Sprintf("detected language: %s", s.language), "sender", s.ID())
+			}
+
+			s.recorder.update(func() {
+				if u.digits == "" {
+					record.Transcription = transcription
+				}
+				record.Language = s.language
+			})
+
+			message := common.TextMessage(s.chooseOption(transcription))
+			if u.digits != "" {
+				message = s.keypadMessage(u.digits)
+			}
+			asked := time.Now()
+			responses, err := assistants.HandleAssistant(s.ctx, s.language, s.ID(), message)
+			s.recorder.update(func() { record.AssistantMs = time.Since(asked).Milliseconds() })
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error receiving response from assistant %s: %s", os.Getenv("ASSISTANT_TOOL"), err), "jid", s.ID())
+				s.recorder.update(func() { record.Error = fmt.Sprintf("error receiving response from assistant: %v", err) })
+				stopThinking()
+				return
+			}
+			s.recorder.update(func() { record.Responses = responses })
+
+			slog.Debug(fmt.Sprintf("response from %v: %v", os.Getenv("ASSISTANT_TOOL"), responses), "callId", s.ID())
+
+			s.offerOptions(responses)
+			s.speak(responses, start, func() {
+				stopThinking()
+				s.recorder.update(func() {
+					if record.FirstAudioMs == 0 {
+						record.FirstAudioMs = time.Since(start).Milliseconds()
+					}
+				})
+			})
+		}
+	}
+}
+
+// setInterruptChannel sets the interrupt channel to true when the user starts speaking and the response from IA is playing
+func (s *CallSession) setInterruptChannel(userBeginSpeakingCh chan bool, done chan bool) {
+	flag1 := false
+	flag2 := false
+	for {
+		select {
+		case playingAudio := <-s.playingAudioCh:
+			flag1 = playingAudio
+		case uBp := <-userBeginSpeakingCh:
+			flag2 = uBp
+		case <-done:
+			return
+		default:
+			time.Sleep(1000 * time.Millisecond) // Wait until receiving to channels
+		}
+		// If the user starts speaking and the response from IA is playing, set audioInterruptCh to true
+		if flag1 && flag2 {
+			slog.Debug("Recibed true in playingAudio and userBeginSpeaking, setting audioInterruptCh to true", "callId", s.ID())
+			s.audioInterruptCh <- true
+			userBeginSpeakingCh <- false
+		}
+	}
+}
+
+// processFromCaller processes audio data from the transport until the user stops speaking
+func (s *CallSession) processFromCaller() {
+	detector := vad.New(s.bot.vadConfig)
+	done := make(chan bool)
+	userBeginSpeakingCh := make(chan bool, 1)
+	userBeginSpeakingCh <- false
+
+	defer close(done)
+
+	go s.setInterruptChannel(userBeginSpeakingCh, done)
+
+	var keys <-chan byte
+	if t, ok := s.transport.(KeypadTransport); ok {
+		keys = t.Keys()
+	}
+	var tones *toneDetector
+	if s.bot.dtmfInband {
+		tones = &toneDetector{}
+	}
+	var entry keypadEntry
+	s.callerActive.Store(false)
+
+	for {
+		frame, err := s.transport.ReadAudio()
+
+		if errors.Is(err, io.EOF) {
+			slog.Info("Received hangup from caller", "callId", s.ID())
+			s.cancel()
+			return
+		} else if err != nil {
+			slog.Error(fmt.Sprintf("error reading message: %s", err), "callId", s.ID())
+			return
+		}
+		s.recorder.recordCaller(frame)
+
+		complete := false
+		if tones != nil {
+			if key, ok := tones.Process(frame); ok {
+				complete = s.pressKey(&entry, key, userBeginSpeakingCh)
+			}
+		}
+	readKeys:
+		for !complete {
+			select {
+			case key := <-keys:
+				complete = s.pressKey(&entry, key, userBeginSpeakingCh)
+			default:
+				break readKeys
+			}
+		}
+		if complete || entry.expired(s.bot.dtmfTimeout) {
+			if s.stream != nil && detector.Speaking() {
+				// Discard the tones sent to the streaming transcription
+				s.stream.Commit(s.ctx)
+			}
+			digits := entry.String()
+			if digits == "" {
+				digits = "#"
+			}
+			slog.Debug(fmt.Sprintf("caller entered keys: %s", digits), "callId", s.ID())
+			select {
+			case s.audioDataCh <- utterance{digits: digits}:
+			case <-s.ctx.Done():
+			}
+			return
+		}
+		if entry.pending() {
+			// The caller is entering keys, the audio is not speech
+			continue
+		}
+
+		// It detects when user starts speaking, so it can interrupt the response from IA
+		event := detector.Process(frame)
+		if s.stream != nil {
+			switch {
+			case event == vad.SpeechStart:
+				// Send the pre-roll together with the start of the speech
+				s.streamAudio(detector.Utterance())
+			case detector.Speaking():
+				s.streamAudio(frame)
+				// The transcription already shows a complete sentence, no need to wait all the hangover
+				if detector.Silence() >= s.bot.vadConfig.Hangover/2 && s.completeSentence() {
+					detector.EndSpeech()
+					event = vad.SpeechEnd
+				}
+			}
+		}
+		switch event {
+		case vad.SpeechStart:
+			slog.Debug("Detected speech", "callId", s.ID())
+			s.callerActive.Store(true)
+			userBeginSpeakingCh <- true
+		case vad.SpeechEnd:
+			slog.Debug("Detected silence", "callId", s.ID())
+			u := utterance{audio: detector.Utterance()}
+			if s.stream != nil {
+				final, err := s.stream.Commit(s.ctx)
+				if err != nil {
+					slog.Warn(fmt.Sprintf("failed to get streaming transcription: %v", err), "callId", s.ID())
+				} else {
+					u.transcription, u.transcribed = final.Text, true
+				}
+			}
+			select {
+			case s.audioDataCh <- u:
+			case <-s.ctx.Done():
+			}
+			return
+		}
+	}
+}
+
+// pressKey adds the key pressed by the caller to the entry and tells whether the entry is complete. The first key
+// interrupts the response being played, and a single key choosing one of the options offered completes the entry.
+func (s *CallSession) pressKey(entry *keypadEntry, key byte, userBeginSpeakingCh chan bool) bool {
+	slog.Debug(fmt.Sprintf("caller pressed key %c", key), "callId", s.ID())
+	s.callerActive.Store(true)
+	if !entry.pending() {
+		select {
+		case userBeginSpeakingCh <- true:
+		default:
+		}
+	}
+	if entry.press(key) {
+		return true
+	}
+	if len(s.buttons) == 0 || len(s.buttons) > 9 {
+		return false
+	}
+	_, ok := s.buttons.Match(entry.String())
+	return ok
+}
+
+// streamAudio sends the audio to the streaming transcription
+func (s *CallSession) streamAudio(data []byte) {
+	if err := s.stream.Write(data); err != nil {
+		slog.Debug(fmt.Sprintf("failed to stream audio: %v", err), "callId", s.ID())
+	}
+}
+
+// hangup ends the call through the transport
+func (s *CallSession) hangup() {
+	if err := s.transport.Hangup(); err != nil {
+		slog.Error(fmt.Sprintf("Failed to send hangup signal: %s", err), "callId", s.ID())
+	} else {
+		slog.Info("Hangup signal sent successfully", "callId", s.ID())
+	}
+}
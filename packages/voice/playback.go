@@ -0,0 +1,197 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/tts"
+)
+
+// sentenceQueueSize is the number of synthesized sentences waiting to be played
+const sentenceQueueSize = 4
+
+// errAudioInterrupted indicates that the user started speaking while the response was playing
+var errAudioInterrupted = errors.New("audio interrupted")
+
+// speak synthesizes the responses sentence by sentence and plays each one as soon as it is ready,
+// so the caller hears the first sentence while the next ones are still being synthesized.
+// Any response still playing is stopped first. ready is called once the first sentence is ready to be played.
+func (s *CallSession) speak(responses common.Responses, start time.Time, ready func()) {
+	s.startPlayback(func(ctx context.Context, queue chan<- []byte) {
+		s.synthesize(ctx, responses, s.language, queue, start, ready)
+	})
+}
+
+// say speaks a text written by freetalkbot, in the language of the call or in the one of the text when the
+// language of the call is not known yet
+func (s *CallSession) say(text string) {
+	language := s.language
+	if language == "" {
+		language = common.DetectLanguage(text)
+	}
+	s.startPlayback(func(ctx context.Context, queue chan<- []byte) {
+		s.synthesize(ctx, common.Responses{{Text: text}}, language, queue, time.Now(), func() {})
+	})
+}
+
+// playAudio plays PCM 16bit signed linear (little-endian) audio at 8kHz
+func (s *CallSession) playAudio(pcm []byte) {
+	s.startPlayback(func(ctx context.Context, queue chan<- []byte) {
+		defer close(queue)
+		queue <- pcm
+	})
+}
+
+// startPlayback stops the response being played and plays the audio queued by produce, which must close the queue
+func (s *CallSession) startPlayback(produce func(ctx context.Context, queue chan<- []byte)) {
+	s.stopSpeaking()
+	s.drainInterrupts()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	done := make(chan struct{})
+	s.cancelSpeaking = cancel
+	s.speakingDone = done
+
+	queue := make(chan []byte, sentenceQueueSize)
+	go produce(ctx, queue)
+	go func() {
+		defer close(done)
+		defer cancel()
+		s.play(ctx, queue)
+	}()
+}
+
+// stopSpeaking cancels the response being played, if any, and waits until it stops
+func (s *CallSession) stopSpeaking() {
+	if s.cancelSpeaking == nil {
+		return
+	}
+	s.cancelSpeaking()
+	<-s.speakingDone
+	s.cancelSpeaking = nil
+}
+
+// drainInterrupts discards the interruptions detected before the current response
+func (s *CallSession) drainInterrupts() {
+	for {
+		select {
+		case <-s.audioInterruptCh:
+		default:
+			return
+		}
+	}
+}
+
+// synthesize converts the responses to audio, one sentence at a time, and queues it to be played.
+// ready is called before queuing the first sentence, and at the end in case there was nothing to play, so it must
+// be safe to call it twice.
+func (s *CallSession) synthesize(ctx context.Context, responses common.Responses, language string, queue chan<- []byte, start time.Time, ready func()) {
+	defer close(queue)
+	defer ready()
+	first := true
+	for _, response := range responses {
+		// Buttons are read as a numbered menu, images and attachments can't be played
+		for _, sentence := range tts.SplitSentences(response.SpokenMenu()) {
+			pcm, sampleRate, err := s.bot.ttsEngine.Synthesize(ctx, sentence, language)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Error(fmt.Sprintf("failed to generate audio from response: %v", err), "callId", s.ID())
+				continue
+			}
+			slog.Debug(fmt.Sprintf("audio generated from response: %s", sentence), "callId", s.ID())
+
+			audioData, err := s.resampleToSlin(pcm, sampleRate)
+			if err != nil {
+				continue
+			}
+			if first {
+				slog.Debug(fmt.Sprintf("completed to create the first audio of the response in %s", time.Since(start).Round(time.Millisecond).String()), "callId", s.ID())
+				first = false
+				ready()
+			}
+
+			select {
+			case queue <- audioData:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// play sends the queued audio to the caller until the queue is done or the user interrupts it
+func (s *CallSession) play(ctx context.Context, queue <-chan []byte) {
+	s.setPlaying(true)
+	defer s.setPlaying(false)
+	for audioData := range queue {
+		err := s.sendAudio(ctx, audioData, s.audioInterruptCh)
+		if errors.Is(err, errAudioInterrupted) {
+			slog.Debug("audio interrupted because user doesn't want to hear me anymore", "callId", s.ID())
+			return
+		} else if err != nil {
+			if ctx.Err() == nil {
+				slog.Error(fmt.Sprintf("failed to send audio: %v", err), "callId", s.ID())
+			}
+			return
+		}
+	}
+	slog.Debug("audio send finished", "callId", s.ID())
+}
+
+// sendAudio sends audio data to the caller, one frame every 20ms, until ctx is done or an interruption is received.
+// A nil interrupts channel never interrupts the audio. The transports playing clips get the whole audio at once,
+// and the frames only keep the time while it plays.
+func (s *CallSession) sendAudio(ctx context.Context, data []byte, interrupts <-chan bool) error {
+	clips, _ := s.transport.(ClipTransport)
+	if clips != nil {
+		if err := clips.PlayClip(data); err != nil {
+			return fmt.Errorf("failed to play clip: %w", err)
+		}
+	}
+	stop := func() {
+		if clips != nil {
+			if err := clips.StopClips(); err != nil {
+				slog.Warn(fmt.Sprintf("failed to stop clip: %v", err), "callId", s.ID())
+			}
+		}
+	}
+
+	var i, chunks int
+	t := time.NewTicker(20 * time.Millisecond)
+	defer t.Stop()
+	for range t.C {
+		select {
+		case <-ctx.Done():
+			stop()
+			return ctx.Err()
+		case audioInterrupt := <-interrupts:
+			if audioInterrupt {
+				stop()
+				return errAudioInterrupted
+			}
+		default:
+			if i >= len(data) {
+				return nil
+			}
+			var chunkLen = FrameSize
+			if i+FrameSize > len(data) {
+				chunkLen = len(data) - i
+			}
+			if clips == nil {
+				if err := s.transport.WriteAudio(data[i : i+chunkLen]); err != nil {
+					return fmt.Errorf("failed to write chunk to transport: %w", err)
+				}
+			}
+			s.recorder.recordBot(data[i : i+chunkLen])
+			chunks++
+			i += chunkLen
+		}
+	}
+	return nil
+}
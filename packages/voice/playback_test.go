@@ -0,0 +1,61 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// clipTransport plays the audio as clips, like mod_audio_fork
+type clipTransport struct {
+	fakeTransport
+	clips [][]byte
+	stops int
+}
+
+func (t *clipTransport) PlayClip(pcm []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clips = append(t.clips, pcm)
+	return nil
+}
+
+func (t *clipTransport) StopClips() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stops++
+	return nil
+}
+
+func TestSendClips(t *testing.T) {
+	transport := &clipTransport{}
+	s := newCallSession(context.Background(), &Bot{}, "call-1", transport)
+	defer s.cancel()
+
+	// The clip is sent at once and the audio keeps the time it plays
+	answer := audio(0x11, 100*time.Millisecond)
+	start := time.Now()
+	if err := s.sendAudio(context.Background(), answer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("the clip of 100ms was sent in %v", elapsed)
+	}
+	if len(transport.clips) != 1 || string(transport.clips[0]) != string(answer) || len(transport.written()) != 0 {
+		t.Fatalf("got %d clips and %d frames, want the answer in one clip", len(transport.clips), len(transport.written()))
+	}
+	if transport.stops != 0 {
+		t.Error("the clip was stopped after playing")
+	}
+
+	// The clip is stopped when the caller interrupts it
+	interrupts := make(chan bool, 1)
+	interrupts <- true
+	if err := s.sendAudio(context.Background(), audio(0x11, 5*time.Second), interrupts); !errors.Is(err, errAudioInterrupted) {
+		t.Fatalf("got %v, want the clip interrupted", err)
+	}
+	if transport.stops != 1 {
+		t.Errorf("the interrupted clip was stopped %d times, want once", transport.stops)
+	}
+}
@@ -0,0 +1,135 @@
+package voice
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+const (
+	defaultIdleTimeout  = 10 * time.Second
+	defaultMaxReprompts = 2
+	// defaultReprompt and defaultGoodbye are translated to the language of the call
+	defaultReprompt = "Are you still there?"
+	defaultGoodbye  = "I can't hear you, so I will hang up. Goodbye!"
+)
+
+// localized returns the value of the variable for the language of the call, like VOICE_GREETING_ES, or the
+// value for any language when it is not set
+func localized(name string, language string) string {
+	if language != "" && language != "none" {
+		if value := os.Getenv(name + "_" + strings.ToUpper(language)); value != "" {
+			return value
+		}
+	}
+	return os.Getenv(name)
+}
+
+// promptText returns the text of the prompt set in the variable, or the english default translated
+// to the language of the call
+func (s *CallSession) promptText(name string, english string) string {
+	if text := localized(name, s.language); text != "" {
+		return text
+	}
+	return common.TranslateFromEnglish(english, s.language)
+}
+
+// greet plays the recorded greeting of VOICE_GREETING_FILE, or says the one of VOICE_GREETING, when they are set
+func (s *CallSession) greet() {
+	if file := localized("VOICE_GREETING_FILE", s.language); file != "" {
+		pcm, err := s.readAudioFile(file)
+		if err == nil {
+			slog.Debug(fmt.Sprintf("playing greeting %s", file), "callId", s.ID())
+			s.playAudio(pcm)
+			return
+		}
+		slog.Error(fmt.Sprintf("failed to read greeting: %v", err), "callId", s.ID())
+	}
+	if text := localized("VOICE_GREETING", s.language); text != "" {
+		slog.Debug(fmt.Sprintf("saying greeting: %s", text), "callId", s.ID())
+		s.say(text)
+	}
+}
+
+// readAudioFile reads a wav file and converts it to PCM 16bit signed linear 8kHz mono
+func (s *CallSession) readAudioFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pcm, sampleRate, err := common.DecodeWav(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s.resampleToSlin(pcm, sampleRate)
+}
+
+// waitUtterance waits for the next utterance of the caller, reprompting them when they stay silent for the idle
+// timeout. It returns false when the call ended, or when the caller didn't answer the reprompts.
+func (s *CallSession) waitUtterance() (utterance, bool) {
+	reprompts := 0
+	idle := s.idleTimer()
+	for {
+		select {
+		case u := <-s.audioDataCh:
+			return u, true
+		case <-s.ctx.Done():
+			return utterance{}, false
+		case <-idle:
+			if reprompts >= s.bot.maxReprompts {
+				slog.Info("Caller didn't answer the reprompts, saying goodbye", "callId", s.ID())
+				s.say(s.promptText("VOICE_GOODBYE", defaultGoodbye))
+				select {
+				case <-s.speakingDone:
+				case <-s.ctx.Done():
+				}
+				s.cancel()
+				return utterance{}, false
+			}
+			reprompts++
+			slog.Debug(fmt.Sprintf("caller is silent, reprompt %d", reprompts), "callId", s.ID())
+			s.say(s.promptText("VOICE_REPROMPT", defaultReprompt))
+			idle = s.idleTimer()
+		}
+	}
+}
+
+// idleTimer returns a channel which is closed when the caller stays silent for the idle timeout after the bot
+// stops speaking. It is never closed when the reprompts are disabled.
+func (s *CallSession) idleTimer() <-chan struct{} {
+	if s.bot.idleTimeout == 0 {
+		return nil
+	}
+	idle := make(chan struct{})
+	speaking := s.speakingDone
+	go func() {
+		if speaking != nil {
+			select {
+			case <-speaking:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+		t := time.NewTimer(s.bot.idleTimeout)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				// The caller is speaking or entering keys, the utterance will come
+				if s.callerActive.Load() {
+					t.Reset(s.bot.idleTimeout)
+					continue
+				}
+				close(idle)
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return idle
+}
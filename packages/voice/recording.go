@@ -0,0 +1,213 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// transcript is the record of a call, saved as JSON next to its recording
+type transcript struct {
+	CallId    string    `json:"call_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Recording string    `json:"recording,omitempty"`
+	Turns     []*turn   `json:"turns"`
+}
+
+// turn is what the caller said or entered on the keypad and the answer of the assistant. The latencies are
+// measured from the end of the speech of the caller.
+type turn struct {
+	Time          time.Time        `json:"time"`
+	Transcription string           `json:"transcription,omitempty"`
+	Digits        string           `json:"digits,omitempty"`
+	Language      string           `json:"language,omitempty"`
+	Responses     common.Responses `json:"responses"`
+	Error         string           `json:"error,omitempty"`
+	// TranscriptionMs is the time the transcription took after the end of the speech, 0 when it was streamed
+	TranscriptionMs int64 `json:"transcription_ms"`
+	AssistantMs     int64 `json:"assistant_ms"`
+	// FirstAudioMs is the time until the first sentence of the answer was ready to be played
+	FirstAudioMs int64 `json:"first_audio_ms"`
+}
+
+// recorder records the audio of the call, the caller in the left channel and the bot in the right one, and
+// the transcript of its turns. All its methods do nothing when it is nil, so the calls are recorded only when
+// VOICE_RECORDINGS_DIR is set.
+type recorder struct {
+	dir        string
+	withAudio  bool
+	mu         sync.Mutex
+	transcript transcript
+	caller     []byte
+	bot        []byte
+	saved      bool
+}
+
+func newRecorder(dir string, withAudio bool, callId string) *recorder {
+	return &recorder{dir: dir, withAudio: withAudio, transcript: transcript{CallId: callId, Start: time.Now(), Turns: []*turn{}}}
+}
+
+// recordCaller adds the audio of the caller, after silence up to the time the frame started. The caller audio is
+// not read while the answer is prepared, and the transports drop or delay the frames meanwhile, so the frames
+// can't just be appended: the gaps are filled with silence to keep the caller aligned with the bot.
+func (r *recorder) recordCaller(frame []byte) {
+	if r == nil || !r.withAudio {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.saved {
+		// The frame was read once it was complete
+		r.caller = appendAt(r.caller, time.Since(r.transcript.Start)-frameDuration(frame), frame)
+	}
+}
+
+// recordBot adds the audio sent to the caller, after silence up to the time elapsed since the call started
+func (r *recorder) recordBot(frame []byte) {
+	if r == nil || !r.withAudio {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.saved {
+		r.bot = appendAt(r.bot, time.Since(r.transcript.Start), frame)
+	}
+}
+
+// appendAt appends the frame to the track at the elapsed time since the call started, adding silence before it
+// when the track is shorter
+func appendAt(track []byte, elapsed time.Duration, frame []byte) []byte {
+	if position := 2 * int(elapsed.Seconds()*SampleRate); len(track) < position {
+		track = append(track, make([]byte, position-len(track))...)
+	}
+	return append(track, frame...)
+}
+
+// frameDuration returns the duration of the PCM 16bit audio at 8kHz
+func frameDuration(frame []byte) time.Duration {
+	return time.Duration(len(frame)/2) * time.Second / SampleRate
+}
+
+// addTurn adds the turn to the transcript, it can be updated until the call is saved
+func (r *recorder) addTurn(t *turn) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transcript.Turns = append(r.transcript.Turns, t)
+}
+
+// update changes a turn of the transcript
+func (r *recorder) update(change func()) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change()
+}
+
+// save writes the recording and the transcript of the call, named after the call ID
+func (r *recorder) save() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.saved {
+		return
+	}
+	r.saved = true
+	r.transcript.End = time.Now()
+
+	// The call ID comes from the transport, it must not escape the directory
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(r.transcript.CallId)
+	if r.withAudio {
+		r.transcript.Recording = name + ".wav"
+		wav := common.EncodeStereoWav(r.caller, r.bot, SampleRate)
+		if err := os.WriteFile(filepath.Join(r.dir, r.transcript.Recording), wav, 0o640); err != nil {
+			slog.Error(fmt.Sprintf("failed to save recording: %v", err), "callId", r.transcript.CallId)
+			r.transcript.Recording = ""
+		}
+	}
+	data, err := json.MarshalIndent(r.transcript, "", "  ")
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to convert transcript to JSON: %v", err), "callId", r.transcript.CallId)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, name+".json"), data, 0o640); err != nil {
+		slog.Error(fmt.Sprintf("failed to save transcript: %v", err), "callId", r.transcript.CallId)
+		return
+	}
+	slog.Info(fmt.Sprintf("Call recorded in %s", r.dir), "callId", r.transcript.CallId)
+}
+
+// sweeping has the recordings directories being swept
+var sweeping sync.Map
+
+// sweepRecordings removes the expired recordings when the bot starts and then periodically, every tenth of the
+// retention up to an hour, so they are removed also when there are no calls. It returns when ctx is done.
+func sweepRecordings(ctx context.Context, dir string, retention time.Duration) {
+	t := time.NewTicker(max(min(retention/10, time.Hour), time.Second))
+	defer t.Stop()
+	for {
+		removeExpiredRecordings(dir, retention)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// removeExpiredRecordings deletes the transcripts older than retention and their recordings. The other files of
+// the directory are kept, only the JSON files with the transcript of a call and the audio they name are removed.
+func removeExpiredRecordings(dir string, retention time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to read recordings directory: %v", err))
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < retention {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var t transcript
+		if err := json.Unmarshal(data, &t); err != nil || t.CallId == "" || t.Start.IsZero() {
+			continue
+		}
+		// The recording is removed first, the transcript is kept to retry when it fails
+		if t.Recording != "" && t.Recording == filepath.Base(t.Recording) {
+			if err := os.Remove(filepath.Join(dir, t.Recording)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				slog.Error(fmt.Sprintf("failed to remove expired recording: %v", err))
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error(fmt.Sprintf("failed to remove expired transcript: %v", err))
+		} else {
+			slog.Debug(fmt.Sprintf("removed expired recording of call %s", t.CallId))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package voice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordingIsAligned(t *testing.T) {
+	r := newRecorder(t.TempDir(), true, "call-1")
+	frame := make([]byte, FrameSize)
+	// The caller spoke during the first second, then the answer was prepared without reading the caller
+	for i := 0; i < 50; i++ {
+		r.recordCaller(frame)
+	}
+	r.transcript.Start = r.transcript.Start.Add(-3 * time.Second)
+	r.recordBot(frame)
+	r.recordCaller(frame)
+
+	// Both frames are placed 3 seconds after the start of the call
+	second := 2 * SampleRate
+	tolerance := second / 10
+	if len(r.bot) < 3*second || len(r.bot) > 3*second+FrameSize+tolerance {
+		t.Errorf("bot track is %d bytes, want the frame after 3s", len(r.bot))
+	}
+	if len(r.caller) < 3*second || len(r.caller) > 3*second+tolerance {
+		t.Errorf("caller track is %d bytes, want the frame ending after 3s", len(r.caller))
+	}
+	if diff := len(r.bot) - len(r.caller); diff < 0 || diff > FrameSize+tolerance {
+		t.Errorf("caller and bot tracks are %d bytes apart", diff)
+	}
+
+	// The frames read in time are not delayed
+	r.recordCaller(frame)
+	if len(r.caller) > 3*second+FrameSize+tolerance {
+		t.Errorf("caller track is %d bytes after the next frame", len(r.caller))
+	}
+}
+
+func TestSweepRecordings(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	expire := func(names ...string) {
+		for _, name := range names {
+			os.Chtimes(filepath.Join(dir, name), old, old)
+		}
+	}
+	newRecorder(dir, true, "old").save()
+	newRecorder(dir, false, "old-keys").save()
+	newRecorder(dir, true, "new").save()
+	for name, content := range map[string]string{"notes.txt": "notes", "music.wav": "", "config.json": `{"name":"config"}`} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expire("old.wav", "old.json", "old-keys.json", "notes.txt", "music.wav", "config.json")
+
+	bot := &Bot{recordingsDir: dir, retention: 10 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		// Swept every second
+		bot.SweepRecordings(ctx)
+	}()
+	removed := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return os.IsNotExist(err)
+	}
+	waitRemoved := func(names ...string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for _, name := range names {
+			for !removed(name) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expired recording %s not removed", name)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	waitRemoved("old.wav", "old.json", "old-keys.json")
+	for _, name := range []string{"new.wav", "new.json", "notes.txt", "music.wav", "config.json"} {
+		if removed(name) {
+			t.Errorf("removed %s, a recording not expired or a file not recorded", name)
+		}
+	}
+
+	// Another channel recording in the same directory doesn't sweep it again
+	other := make(chan struct{})
+	go func() {
+		defer close(other)
+		(&Bot{recordingsDir: dir + "/", retention: time.Minute}).SweepRecordings(context.Background())
+	}()
+	select {
+	case <-other:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the directory is swept twice")
+	}
+
+	// A recording expiring later is removed without waiting for a call
+	expire("new.wav", "new.json")
+	waitRemoved("new.wav", "new.json")
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sweep didn't stop")
+	}
+	if removed("notes.txt") || removed("music.wav") || removed("config.json") {
+		t.Error("removed a file not recorded")
+	}
+
+	// The directory is swept again when the channel starts again
+	newRecorder(dir, false, "restart").save()
+	expire("restart.json")
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	bot.SweepRecordings(done)
+	if !removed("restart.json") {
+		t.Error("expired recording not removed after the restart")
+	}
+}
@@ -0,0 +1,36 @@
+package voice
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/zaf/resample"
+)
+
+// resampleToSlin converts PCM 16bit linear mono audio of any sample rate to PCM 16bit linear 8kHz Mono
+func (s *CallSession) resampleToSlin(data []byte, sampleRate int) ([]byte, error) {
+	if sampleRate == SampleRate {
+		return data, nil
+	}
+
+	// Create a new resampler to convert the audio to PCM 16bit linear 8kHz Mono
+	var out bytes.Buffer
+
+	resampler, err := resample.New(&out, float64(sampleRate), SampleRate, 1, 3, 6)
+	if err != nil {
+		slog.ErrorContext(s.ctx, "failed to create resampler", slog.Any("error", err), "callId", s.ID())
+		return nil, err
+	}
+	_, err = resampler.Write(data)
+	if err != nil {
+		slog.ErrorContext(s.ctx, "resampling write failed", slog.Any("error", err), "callId", s.ID())
+		return nil, err
+	}
+	err = resampler.Close()
+	if err != nil {
+		slog.ErrorContext(s.ctx, "failed to close resampler", slog.Any("error", err), "callId", s.ID())
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
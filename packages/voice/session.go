@@ -0,0 +1,160 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/stt"
+)
+
+// CallSession holds the state of a single call. One is created per connection,
+// so concurrent calls never share their id, language, buffers or channels.
+type CallSession struct {
+	id        string
+	bot       *Bot
+	transport Transport
+	language  string
+	audioData []byte
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// Channel to signal when the response from IA is playing
+	playingAudioCh chan bool
+	// Channel to send the user speech
+	audioDataCh chan utterance
+	// Channel to detect interrupt
+	audioInterruptCh chan bool
+	// callerActive is true while the caller is speaking or entering keys
+	callerActive atomic.Bool
+
+	// stream is the streaming transcription session of the call, nil when streaming is not used
+	stream    stt.Stream
+	partialMu sync.Mutex
+	partial   string
+
+	// buttons are the options read to the caller in the last response, the next utterance can choose one of them
+	buttons common.Buttons
+
+	// cancelSpeaking stops the response being played and speakingDone is closed once it stopped
+	cancelSpeaking context.CancelFunc
+	speakingDone   chan struct{}
+
+	// recorder records the call, nil when the calls are not recorded
+	recorder *recorder
+}
+
+// utterance is the speech of the user in a turn, transcribed is true when it was already transcribed by the stream.
+// digits are set instead when the user entered keys on the keypad.
+type utterance struct {
+	audio         []byte
+	transcription string
+	transcribed   bool
+	digits        string
+}
+
+// newCallSession creates the session of the call
+func newCallSession(pCtx context.Context, b *Bot, id string, t Transport) *CallSession {
+	ctx, cancel := context.WithTimeout(pCtx, MaxCallDuration)
+	s := &CallSession{
+		id:               id,
+		bot:              b,
+		transport:        t,
+		ctx:              ctx,
+		cancel:           cancel,
+		playingAudioCh:   make(chan bool, 20),
+		audioDataCh:      make(chan utterance),
+		audioInterruptCh: make(chan bool, 20),
+	}
+	if b.recordingsDir != "" {
+		s.recorder = newRecorder(b.recordingsDir, b.recordAudio, id)
+	}
+	return s
+}
+
+// saveRecording saves the recording of the call
+func (s *CallSession) saveRecording() {
+	s.recorder.save()
+}
+
+// ID returns the call ID
+func (s *CallSession) ID() string {
+	return s.id
+}
+
+// setPlaying notifies whether the response from IA is playing, unless the call already ended
+func (s *CallSession) setPlaying(playing bool) {
+	select {
+	case s.playingAudioCh <- playing:
+	case <-s.ctx.Done():
+	}
+}
+
+// openStream starts the streaming transcription of the call, if it fails the speech is transcribed after each turn
+func (s *CallSession) openStream() {
+	stream, err := s.bot.sttStreamer.NewStream(s.ctx, SampleRate, s.language)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to open streaming transcription, falling back to transcription per turn: %v", err), "callId", s.ID())
+		return
+	}
+	s.stream = stream
+	go s.followTranscripts()
+}
+
+// followTranscripts keeps the latest partial transcript, used to detect the end of the turn earlier
+func (s *CallSession) followTranscripts() {
+	for t := range s.stream.Transcripts() {
+		if t.Final {
+			s.setPartial("")
+			continue
+		}
+		slog.Debug(fmt.Sprintf("partial transcription: %s", t.Text), "callId", s.ID())
+		s.setPartial(t.Text)
+	}
+}
+
+func (s *CallSession) setPartial(text string) {
+	s.partialMu.Lock()
+	defer s.partialMu.Unlock()
+	s.partial = text
+}
+
+// completeSentence tells if the partial transcript ends a sentence
+func (s *CallSession) completeSentence() bool {
+	s.partialMu.Lock()
+	defer s.partialMu.Unlock()
+	return strings.HasSuffix(s.partial, ".") || strings.HasSuffix(s.partial, "?") || strings.HasSuffix(s.partial, "!")
+}
+
+// chooseOption replaces the transcription by the payload of the option chosen by the caller, if any,
+// and keeps the options offered in the responses for the next turn
+func (s *CallSession) chooseOption(transcription string) string {
+	if button, ok := s.buttons.Match(transcription); ok {
+		slog.Debug(fmt.Sprintf("caller chose option: %s", button.Title), "callId", s.ID())
+		return button.Reply()
+	}
+	return transcription
+}
+
+// keypadMessage returns the message with the keys entered by the caller, or the payload of the option chosen with them
+func (s *CallSession) keypadMessage(digits string) common.Message {
+	if button, ok := s.buttons.Match(digits); ok {
+		slog.Debug(fmt.Sprintf("caller chose option: %s", button.Title), "callId", s.ID())
+		return common.TextMessage(button.Reply())
+	}
+	return common.Message{Digits: digits}
+}
+
+// offerOptions keeps the buttons of the last response offering them, so the caller can choose one in the next turn
+func (s *CallSession) offerOptions(responses common.Responses) {
+	s.buttons = nil
+	for _, r := range responses {
+		if len(r.Buttons) > 0 {
+			s.buttons = r.Buttons
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// defaultThinkingDelay is how long the answer can take before the thinking cue is played
+const defaultThinkingDelay = time.Second
+
+// think plays the thinking cue while the answer is being prepared, if it takes longer than the thinking delay.
+// The recorded cue of VOICE_THINKING_FILE is played in a loop, unless VOICE_THINKING_LOOP is false, and the
+// text of VOICE_THINKING is said once. The returned function stops the cue and waits until it stopped.
+//
+// The cue is not a response: it is not played through startPlayback, so the answer can stop it once its first
+// sentence is ready, and it never takes the interruptions meant for the responses. It is only stopped by the
+// returned function or the end of the call.
+func (s *CallSession) think() func() {
+	file := localized("VOICE_THINKING_FILE", s.language)
+	text := localized("VOICE_THINKING", s.language)
+	if file == "" && text == "" {
+		return func() {}
+	}
+	// The previous response must not play over the cue
+	s.stopSpeaking()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		timer := time.NewTimer(s.bot.thinkingDelay)
+		defer timer.Stop()
+
+		// The cue is prepared during the delay
+		cue, loop, err := s.thinkingCue(ctx, file, text)
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to prepare thinking cue: %v", err), "callId", s.ID())
+			return
+		}
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+
+		slog.Debug("playing thinking cue", "callId", s.ID())
+		for {
+			if err := s.sendAudio(ctx, cue, nil); err != nil {
+				if ctx.Err() == nil {
+					slog.Error(fmt.Sprintf("failed to send thinking cue: %v", err), "callId", s.ID())
+				}
+				return
+			}
+			if !loop {
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+// thinkingCue returns the audio of the cue, read from the file or synthesized from the text, and whether
+// it is played in a loop. The cues are kept by the bot, so they are prepared once.
+func (s *CallSession) thinkingCue(ctx context.Context, file string, text string) ([]byte, bool, error) {
+	if file != "" {
+		if cue, ok := s.bot.cues.Load(file); ok {
+			return cue.([]byte), s.bot.thinkingLoop, nil
+		}
+		cue, err := s.readAudioFile(file)
+		if err != nil {
+			return nil, false, err
+		}
+		s.bot.cues.Store(file, cue)
+		return cue, s.bot.thinkingLoop, nil
+	}
+
+	language := s.language
+	if language == "" {
+		language = common.DetectLanguage(text)
+	}
+	key := language + ":" + text
+	if cue, ok := s.bot.cues.Load(key); ok {
+		return cue.([]byte), false, nil
+	}
+	pcm, sampleRate, err := s.bot.ttsEngine.Synthesize(ctx, text, language)
+	if err != nil {
+		return nil, false, err
+	}
+	cue, err := s.resampleToSlin(pcm, sampleRate)
+	if err != nil {
+		return nil, false, err
+	}
+	s.bot.cues.Store(key, cue)
+	return cue, false, nil
+}
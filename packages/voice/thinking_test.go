@@ -0,0 +1,99 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+)
+
+// fakeTransport keeps the frames written to the caller, the caller never speaks
+type fakeTransport struct {
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (t *fakeTransport) ReadAudio() ([]byte, error) {
+	select {}
+}
+
+func (t *fakeTransport) WriteAudio(frame []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.frames = append(t.frames, append([]byte(nil), frame...))
+	return nil
+}
+
+func (t *fakeTransport) Hangup() error { return nil }
+
+// written returns the frames written so far
+func (t *fakeTransport) written() [][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([][]byte(nil), t.frames...)
+}
+
+// audio returns d of audio at 8kHz with every byte set to b
+func audio(b byte, d time.Duration) []byte {
+	return bytes.Repeat([]byte{b}, int(d/(20*time.Millisecond))*FrameSize)
+}
+
+func TestThinkingCue(t *testing.T) {
+	cueFile := filepath.Join(t.TempDir(), "cue.wav")
+	if err := os.WriteFile(cueFile, common.EncodeWav(audio(0x22, 100*time.Millisecond), SampleRate), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VOICE_THINKING_FILE", cueFile)
+	transport := &fakeTransport{}
+	s := newCallSession(context.Background(), &Bot{}, "call-1", transport)
+	defer s.cancel()
+
+	// The previous answer is still playing when the caller asks the next question
+	s.playAudio(audio(0x11, 5*time.Second))
+	for len(transport.written()) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopThinking := s.think()
+	// The caller interrupts the answer while the cue plays
+	s.audioInterruptCh <- true
+
+	deadline := time.After(5 * time.Second)
+	for cue := 0; cue < 5; {
+		select {
+		case <-deadline:
+			t.Fatalf("%d frames of the cue played, want 5", cue)
+		case <-time.After(10 * time.Millisecond):
+		}
+		cue = 0
+		for _, frame := range transport.written() {
+			if frame[0] == 0x22 {
+				cue++
+			}
+		}
+	}
+	stopThinking()
+
+	frames := transport.written()
+	cueStarted := false
+	for i, frame := range frames {
+		switch {
+		case frame[0] == 0x22:
+			cueStarted = true
+		case cueStarted:
+			t.Fatalf("frame %d of the previous answer played over the cue", i)
+		}
+	}
+	select {
+	case <-s.speakingDone:
+	default:
+		t.Error("previous answer still playing")
+	}
+	if len(s.audioInterruptCh) != 1 {
+		t.Error("the cue took the interruption of the answer")
+	}
+}
@@ -0,0 +1,252 @@
+package webchat
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipem1210/freetalkbot/packages/channels"
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/stt"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultAddr = ":8090"
+	// sessionCookie keeps the session ID in the browser
+	sessionCookie = "freetalkbot_session"
+	// maxMessageSize is the maximum size in bytes of a message from the browser, recorded audio included
+	maxMessageSize = 10 * 1024 * 1024
+	writeWait      = 10 * time.Second
+)
+
+//go:embed static
+var static embed.FS
+
+var sttEngine stt.STT
+
+// inMessage is a message sent by the widget. Type is text, button or audio. Data is the recorded audio, base64 encoded.
+type inMessage struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Payload  string `json:"payload"`
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// outMessage is a message sent to the widget. Type is session, transcription, responses or error.
+type outMessage struct {
+	Type      string           `json:"type"`
+	SessionId string           `json:"session_id,omitempty"`
+	Text      string           `json:"text,omitempty"`
+	Responses common.Responses `json:"responses,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// Server is the webchat channel. It serves the widget to embed in websites and the WebSocket it talks with.
+type Server struct {
+	http     *http.Server
+	upgrader websocket.Upgrader
+	sessions *channels.StateStore[string, channels.Conversation]
+	conns    sync.WaitGroup
+}
+
+// New creates the webchat channel with the shared engines
+func New(engines channels.Engines) (*Server, error) {
+	sttEngine = engines.STT
+	addr := os.Getenv("WEBCHAT_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	srv := &Server{sessions: channels.NewStateStore[string, channels.Conversation](channels.ConversationTTL)}
+	srv.upgrader.CheckOrigin = checkOrigin(os.Getenv("WEBCHAT_ALLOWED_ORIGINS"))
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.Default()
+	router.GET("/", serveStatic("static/index.html", "text/html; charset=utf-8"))
+	router.GET("/widget.js", serveStatic("static/widget.js", "application/javascript"))
+	router.GET("/ws", srv.handleWebSocket)
+	srv.http = &http.Server{Addr: addr, Handler: router}
+	return srv, nil
+}
+
+func (srv *Server) Name() string {
+	return "webchat"
+}
+
+// Start serves the widget and the chats until ctx is done
+func (srv *Server) Start(ctx context.Context) error {
+	// The chats end when ctx is done
+	srv.http.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		srv.shutdown()
+	}()
+
+	slog.Info(fmt.Sprintf("Starting webchat server on %s", srv.http.Addr))
+	if err := srv.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop shuts down the server and waits until the chats in progress are closed
+func (srv *Server) Stop() error {
+	err := srv.shutdown()
+	srv.conns.Wait()
+	return err
+}
+
+func (srv *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.http.Shutdown(ctx)
+}
+
+// checkOrigin allows the websites in WEBCHAT_ALLOWED_ORIGINS, separated by commas, to embed the widget.
+// * allows any website, and when it is not set only the pages served by freetalkbot can use it.
+func checkOrigin(allowed string) func(r *http.Request) bool {
+	if allowed == "" {
+		return nil
+	}
+	origins := strings.Split(allowed, ",")
+	for i := range origins {
+		origins[i] = strings.TrimSpace(origins[i])
+	}
+	return func(r *http.Request) bool {
+		return slices.Contains(origins, "*") || slices.Contains(origins, r.Header.Get("Origin"))
+	}
+}
+
+func serveStatic(name string, contentType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := static.ReadFile(name)
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
+
+// sessionID returns the session of the visitor, sent by the widget or kept in the cookie, or a new one
+func sessionID(c *gin.Context) string {
+	id := c.Query("session")
+	if id == "" {
+		id, _ = c.Cookie(sessionCookie)
+	}
+	if _, err := uuid.FromString(id); err == nil {
+		return id
+	}
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+// handleWebSocket talks with the widget until the browser or the server closes the connection
+func (srv *Server) handleWebSocket(c *gin.Context) {
+	id := sessionID(c)
+	cookie := &http.Cookie{Name: sessionCookie, Value: id, Path: "/", MaxAge: int(channels.ConversationTTL.Seconds()), HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	conn, err := srv.upgrader.Upgrade(c.Writer, c.Request, http.Header{"Set-Cookie": {cookie.String()}})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to upgrade to websocket: %v", err), "session", id)
+		return
+	}
+	srv.conns.Add(1)
+	defer srv.conns.Done()
+	defer conn.Close()
+
+	ctx := c.Request.Context()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetReadLimit(maxMessageSize)
+
+	slog.Info("Webchat connected", "session", id)
+	srv.send(conn, id, outMessage{Type: "session", SessionId: id})
+	for {
+		var m inMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && ctx.Err() == nil {
+				slog.Debug(fmt.Sprintf("webchat connection closed: %v", err), "session", id)
+			}
+			return
+		}
+		srv.handleMessage(ctx, conn, id, m)
+	}
+}
+
+// handleMessage sends the message of the visitor to the assistant and its responses to the widget
+func (srv *Server) handleMessage(ctx context.Context, conn *websocket.Conn, id string, m inMessage) {
+	defer srv.sessions.Lock(id)()
+	current, _ := srv.sessions.Get(id)
+
+	text := m.Text
+	switch m.Type {
+	case "text", "button":
+		slog.Info("Received text message", "session", id)
+	case "audio":
+		slog.Info("Received audio message", "session", id)
+		transcription, err := sttEngine.Transcribe(ctx, stt.Audio{Data: m.Data, FileName: "audio" + audioExtension(m.MimeType)}, "")
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error transcribing audio message: %s", err), "session", id)
+			srv.send(conn, id, outMessage{Type: "error", Error: "The audio could not be transcribed"})
+			return
+		}
+		text = transcription
+		srv.send(conn, id, outMessage{Type: "transcription", Text: text})
+	default:
+		srv.send(conn, id, outMessage{Type: "error", Error: fmt.Sprintf("Unsupported message type %q", m.Type)})
+		return
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	slog.Debug(fmt.Sprintf("message received: %s", text), "session", id)
+
+	// The payload of a button doesn't tell the language, its title does
+	language := current.DetectLanguage(text)
+	message := common.TextMessage(text)
+	if m.Type == "button" && m.Payload != "" {
+		message.Text = m.Payload
+	}
+	responses, err := current.Ask(ctx, "webchat-"+id, language, message, func() { srv.sessions.Save(id, current) })
+	if err != nil {
+		slog.Error(err.Error(), "session", id)
+		srv.send(conn, id, outMessage{Type: "error", Error: "The assistant is not available"})
+		return
+	}
+	srv.send(conn, id, outMessage{Type: "responses", Responses: responses})
+}
+
+func (srv *Server) send(conn *websocket.Conn, id string, m outMessage) {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteJSON(m); err != nil {
+		slog.Debug(fmt.Sprintf("failed to send message to webchat: %v", err), "session", id)
+	}
+}
+
+// audioExtension returns the extension of the audio recorded by the browser, used by the STT tool to read it
+func audioExtension(mimeType string) string {
+	switch strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]) {
+	case "audio/ogg":
+		return ".ogg"
+	case "audio/mp4", "audio/aac":
+		return ".m4a"
+	case "audio/wav", "audio/x-wav":
+		return ".wav"
+	case "audio/mpeg":
+		return ".mp3"
+	default:
+		return ".webm"
+	}
+}
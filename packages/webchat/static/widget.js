@@ -0,0 +1,219 @@
+// Freetalkbot webchat widget. Embed it in any page with:
+//   <script src="https://your-freetalkbot-host/widget.js" data-title="Support"></script>
+// The website must be allowed in WEBCHAT_ALLOWED_ORIGINS.
+(function () {
+  "use strict";
+
+  var script = document.currentScript;
+  var base = new URL(script.src, window.location.href);
+  var title = script.getAttribute("data-title") || "Chat";
+  var storageKey = "freetalkbot_session";
+
+  var style = document.createElement("style");
+  style.textContent = [
+    ".ftb-toggle{position:fixed;bottom:20px;right:20px;width:56px;height:56px;border-radius:50%;border:none;background:#1e6fd9;color:#fff;font-size:24px;cursor:pointer;box-shadow:0 2px 8px rgba(0,0,0,.3);z-index:2147483000}",
+    ".ftb-panel{position:fixed;bottom:88px;right:20px;width:340px;max-width:calc(100vw - 40px);height:480px;max-height:calc(100vh - 120px);display:none;flex-direction:column;background:#fff;border-radius:8px;box-shadow:0 2px 12px rgba(0,0,0,.3);font-family:sans-serif;font-size:14px;z-index:2147483000}",
+    ".ftb-panel.ftb-open{display:flex}",
+    ".ftb-header{padding:12px;background:#1e6fd9;color:#fff;border-radius:8px 8px 0 0;font-weight:bold}",
+    ".ftb-messages{flex:1;overflow-y:auto;padding:12px}",
+    ".ftb-message{margin:6px 0;padding:8px 10px;border-radius:8px;max-width:80%;white-space:pre-wrap;word-wrap:break-word}",
+    ".ftb-user{background:#1e6fd9;color:#fff;margin-left:auto}",
+    ".ftb-bot{background:#eee;color:#222}",
+    ".ftb-error{background:#fdd;color:#900}",
+    ".ftb-message img{max-width:100%;border-radius:4px;display:block}",
+    ".ftb-buttons button{margin:4px 4px 0 0;padding:6px 10px;border:1px solid #1e6fd9;border-radius:14px;background:#fff;color:#1e6fd9;cursor:pointer}",
+    ".ftb-form{display:flex;border-top:1px solid #ddd}",
+    ".ftb-form input{flex:1;border:none;padding:12px;font-size:14px;outline:none}",
+    ".ftb-form button{border:none;background:none;padding:0 12px;font-size:18px;cursor:pointer}",
+    ".ftb-recording{color:#d00}"
+  ].join("\n");
+  document.head.appendChild(style);
+
+  var toggle = document.createElement("button");
+  toggle.className = "ftb-toggle";
+  toggle.setAttribute("aria-label", title);
+  toggle.textContent = "\u{1F4AC}";
+
+  var panel = document.createElement("div");
+  panel.className = "ftb-panel";
+  panel.innerHTML =
+    '<div class="ftb-header"></div>' +
+    '<div class="ftb-messages"></div>' +
+    '<form class="ftb-form">' +
+    '<input type="text" autocomplete="off">' +
+    '<button type="button" class="ftb-mic" aria-label="Record">\u{1F3A4}</button>' +
+    '<button type="submit" aria-label="Send">➤</button>' +
+    "</form>";
+  panel.querySelector(".ftb-header").textContent = title;
+  document.body.appendChild(panel);
+  document.body.appendChild(toggle);
+
+  var messages = panel.querySelector(".ftb-messages");
+  var form = panel.querySelector(".ftb-form");
+  var input = form.querySelector("input");
+  var mic = form.querySelector(".ftb-mic");
+  var socket = null;
+  var pending = [];
+
+  toggle.addEventListener("click", function () {
+    panel.classList.toggle("ftb-open");
+    if (panel.classList.contains("ftb-open")) {
+      connect();
+      input.focus();
+    }
+  });
+
+  function connect() {
+    if (socket && socket.readyState <= WebSocket.OPEN) {
+      return;
+    }
+    var url = new URL("/ws", base);
+    url.protocol = base.protocol === "https:" ? "wss:" : "ws:";
+    var session = window.localStorage.getItem(storageKey);
+    if (session) {
+      url.searchParams.set("session", session);
+    }
+    socket = new WebSocket(url);
+    socket.onopen = function () {
+      while (pending.length) {
+        socket.send(pending.shift());
+      }
+    };
+    socket.onmessage = function (event) {
+      receive(JSON.parse(event.data));
+    };
+  }
+
+  function send(message) {
+    var data = JSON.stringify(message);
+    if (socket && socket.readyState === WebSocket.OPEN) {
+      socket.send(data);
+    } else {
+      pending.push(data);
+      connect();
+    }
+  }
+
+  function receive(message) {
+    switch (message.type) {
+      case "session":
+        window.localStorage.setItem(storageKey, message.session_id);
+        break;
+      case "transcription":
+        addMessage("ftb-user", message.text);
+        break;
+      case "responses":
+        (message.responses || []).forEach(showResponse);
+        break;
+      case "error":
+        addMessage("ftb-error", message.error);
+        break;
+    }
+  }
+
+  function addMessage(kind, text) {
+    var element = document.createElement("div");
+    element.className = "ftb-message " + kind;
+    if (text) {
+      element.textContent = text;
+    }
+    messages.appendChild(element);
+    messages.scrollTop = messages.scrollHeight;
+    return element;
+  }
+
+  // The urls of the responses come from the assistant, only http(s) ones are shown, never javascript: or data:
+  function safeUrl(value) {
+    try {
+      var url = new URL(value, window.location.href);
+      return url.protocol === "http:" || url.protocol === "https:" ? url.href : null;
+    } catch (e) {
+      return null;
+    }
+  }
+
+  function showResponse(response) {
+    var element = addMessage("ftb-bot", response.text);
+    var imageUrl = response.image && safeUrl(response.image);
+    if (imageUrl) {
+      var image = document.createElement("img");
+      image.src = imageUrl;
+      image.alt = "";
+      image.onload = function () {
+        messages.scrollTop = messages.scrollHeight;
+      };
+      element.appendChild(image);
+    }
+    var attachmentUrl = response.attachment && response.attachment.url && safeUrl(response.attachment.url);
+    if (attachmentUrl) {
+      var link = document.createElement("a");
+      link.href = attachmentUrl;
+      link.target = "_blank";
+      link.rel = "noopener";
+      link.textContent = response.attachment.file_name || response.attachment.url;
+      element.appendChild(document.createElement("br"));
+      element.appendChild(link);
+    }
+    if (response.buttons && response.buttons.length) {
+      var buttons = document.createElement("div");
+      buttons.className = "ftb-buttons";
+      response.buttons.forEach(function (b) {
+        var button = document.createElement("button");
+        button.type = "button";
+        button.textContent = b.title;
+        button.addEventListener("click", function () {
+          addMessage("ftb-user", b.title);
+          send({ type: "button", text: b.title, payload: b.payload });
+        });
+        buttons.appendChild(button);
+      });
+      element.appendChild(buttons);
+    }
+  }
+
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+    var text = input.value.trim();
+    if (!text) {
+      return;
+    }
+    input.value = "";
+    addMessage("ftb-user", text);
+    send({ type: "text", text: text });
+  });
+
+  // The microphone button starts recording, and a second click sends the audio
+  var recorder = null;
+  if (!window.MediaRecorder || !navigator.mediaDevices) {
+    mic.style.display = "none";
+  }
+  mic.addEventListener("click", function () {
+    if (recorder && recorder.state === "recording") {
+      recorder.stop();
+      return;
+    }
+    navigator.mediaDevices.getUserMedia({ audio: true }).then(function (stream) {
+      var chunks = [];
+      recorder = new MediaRecorder(stream);
+      recorder.ondataavailable = function (event) {
+        chunks.push(event.data);
+      };
+      recorder.onstop = function () {
+        mic.classList.remove("ftb-recording");
+        stream.getTracks().forEach(function (track) {
+          track.stop();
+        });
+        var blob = new Blob(chunks, { type: recorder.mimeType });
+        var reader = new FileReader();
+        reader.onload = function () {
+          send({ type: "audio", mime_type: blob.type, data: reader.result.split(",")[1] });
+        };
+        reader.readAsDataURL(blob);
+      };
+      recorder.start();
+      mic.classList.add("ftb-recording");
+    }).catch(function () {
+      addMessage("ftb-error", "The microphone is not available");
+    });
+  });
+})();
@@ -14,36 +14,48 @@ import (
 
 var assistantLanguage string
 
-func InitializeCallbackServer() {
+// callbackAddr is the address where the assistant sends the messages it starts, like reminders
+const callbackAddr = ":5034"
+
+// newCallbackServer creates the server receiving the messages from the assistant
+func newCallbackServer() *http.Server {
 	assistantLanguage = os.Getenv("ASSISTANT_LANGUAGE")
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 	router.POST("/bot", handleBotEndpoint)
-	slog.Info("Starting callback server on port 5034")
-	router.Run(":5034")
+	return &http.Server{Addr: callbackAddr, Handler: router}
 }
 
 func handleBotEndpoint(c *gin.Context) {
-	var requestBody map[string]interface{}
-	if err := c.BindJSON(&requestBody); err != nil {
+	var response common.Response
+	if err := c.BindJSON(&response); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
 		return
 	}
-	recipientID := fmt.Sprintf("%v", requestBody["recipient_id"])
-	text := fmt.Sprintf("%v", requestBody["text"])
-	responses := common.Responses{}
-	responses = append(responses, common.Response{RecipientId: recipientID, Text: text})
+	recipientID := response.RecipientId
+	responses := common.Responses{response}
+
+	// The language of the user that will receive the reply, not of the last message received from anyone
+	language := assistantLanguage
+	voice := replyWithVoice(false)
+	if c, ok := conversations.Get(recipientID); ok {
+		language = c.Language
+		voice = replyWithVoice(c.receivedAudio)
+	}
 
 	for _, r := range responses {
 		if !strings.Contains(language, assistantLanguage) && assistantLanguage != language {
 			r.Text, _ = gt.Translate(r.Text, assistantLanguage, language)
+			for i, button := range r.Buttons {
+				if title, err := gt.Translate(button.Title, assistantLanguage, language); err == nil && title != "" {
+					r.Buttons[i].Title = title
+				}
+			}
 		}
 
-		result, err := sendWhatsappMessage(recipientID, r.Text)
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error sending response: %s", err), "jid", jid)
+		if err := sendResponse(recipientID, language, r, voice); err != nil {
+			slog.Error(fmt.Sprintf("Error sending response: %s", err), "jid", recipientID)
 		}
-		slog.Info(result, "jid", jid)
 	}
 	c.JSON(http.StatusOK, responses)
 }
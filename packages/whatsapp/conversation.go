@@ -0,0 +1,17 @@
+package whatsapp
+
+import (
+	"github.com/felipem1210/freetalkbot/packages/channels"
+)
+
+// conversation is the state of the chat with a user
+type conversation struct {
+	channels.Conversation
+	jid           string
+	lastMessageId string
+	// receivedAudio is true when the last message of the user was a voice note
+	receivedAudio bool
+}
+
+// conversations keeps the conversations by JID. It is shared by the event handler and the callback server.
+var conversations = channels.NewStateStore[string, conversation](channels.ConversationTTL)
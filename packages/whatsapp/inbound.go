@@ -0,0 +1,117 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// maxMediaSize is the maximum size in bytes of the images and documents downloaded to be sent to the assistant
+const maxMediaSize = 20 * 1024 * 1024
+
+// unsupportedMessageReply is sent when the message can't be understood, translated to the language of the user
+const unsupportedMessageReply = "Sorry, I can only understand text, voice notes, images, documents and locations."
+
+// errUnsupportedMessage is returned for the messages the assistants can't understand, like videos, stickers or contacts
+var errUnsupportedMessage = errors.New("unsupported message")
+
+// readMessage normalizes the WhatsApp message. The message is empty when it doesn't need a reply, like reactions or edits.
+func readMessage(v *events.Message, jid string) (common.Message, error) {
+	msg := v.Message
+	if document := msg.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage(); document != nil {
+		msg = &waE2E.Message{DocumentMessage: document}
+	}
+
+	switch {
+	case msg.GetConversation() != "":
+		slog.Info("Received text message", "jid", jid)
+		return common.TextMessage(msg.GetConversation()), nil
+	case msg.GetExtendedTextMessage() != nil:
+		// Replies, and messages with links
+		slog.Info("Received text message", "jid", jid)
+		return common.TextMessage(msg.GetExtendedTextMessage().GetText()), nil
+	case msg.GetListResponseMessage() != nil:
+		// The ID of the row is the number of the chosen option
+		slog.Info("Received list response message", "jid", jid)
+		return common.TextMessage(msg.GetListResponseMessage().GetSingleSelectReply().GetSelectedRowID()), nil
+	case msg.GetAudioMessage() != nil:
+		slog.Info("Received audio message", "jid", jid)
+		transcription, err := transcribeAudio(msg.GetAudioMessage(), v.Info.ID, jid)
+		return common.TextMessage(transcription), err
+	case msg.GetImageMessage() != nil:
+		slog.Info("Received image message", "jid", jid)
+		image := msg.GetImageMessage()
+		media, err := downloadMedia(image, image.GetMimetype(), "", image.GetFileLength(), jid)
+		return common.Message{Caption: image.GetCaption(), Media: media}, err
+	case msg.GetDocumentMessage() != nil:
+		slog.Info("Received document message", "jid", jid)
+		document := msg.GetDocumentMessage()
+		fileName := document.GetFileName()
+		if fileName == "" {
+			fileName = document.GetTitle()
+		}
+		media, err := downloadMedia(document, document.GetMimetype(), fileName, document.GetFileLength(), jid)
+		return common.Message{Caption: document.GetCaption(), Media: media}, err
+	case msg.GetLocationMessage() != nil:
+		slog.Info("Received location message", "jid", jid)
+		location := msg.GetLocationMessage()
+		return common.Message{Location: &common.Location{
+			Latitude:  location.GetDegreesLatitude(),
+			Longitude: location.GetDegreesLongitude(),
+			Name:      location.GetName(),
+			Address:   location.GetAddress(),
+		}}, nil
+	case msg.GetLiveLocationMessage() != nil:
+		slog.Info("Received live location message", "jid", jid)
+		location := msg.GetLiveLocationMessage()
+		return common.Message{
+			Caption: location.GetCaption(),
+			Location: &common.Location{
+				Latitude:  location.GetDegreesLatitude(),
+				Longitude: location.GetDegreesLongitude(),
+			},
+		}, nil
+	case msg.GetVideoMessage() != nil, msg.GetStickerMessage() != nil, msg.GetContactMessage() != nil,
+		msg.GetContactsArrayMessage() != nil, msg.GetPollCreationMessage() != nil:
+		return common.Message{}, errUnsupportedMessage
+	default:
+		return common.Message{}, nil
+	}
+}
+
+// downloadMedia downloads and decrypts the image or document in memory. Files bigger than maxMediaSize are not
+// downloaded, so the assistant only knows that they were sent.
+func downloadMedia(msg whatsmeow.DownloadableMessage, mimeType string, fileName string, size uint64, jid string) (*common.Media, error) {
+	media := &common.Media{MimeType: mimeType, FileName: fileName}
+	if size > maxMediaSize {
+		slog.Warn(fmt.Sprintf("Media of %d bytes is too big to be downloaded", size), "jid", jid)
+		return media, nil
+	}
+	data, err := whatsappClient.Download(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	media.Data = data
+	return media, nil
+}
+
+// sendUnsupportedReply tells the user that the message can't be understood. WHATSAPP_UNSUPPORTED_REPLY replaces
+// the default reply, and it is sent as it is.
+func sendUnsupportedReply(jid string, language string) {
+	reply := os.Getenv("WHATSAPP_UNSUPPORTED_REPLY")
+	if reply == "" {
+		reply = common.TranslateFromEnglish(unsupportedMessageReply, language)
+	}
+	result, err := sendWhatsappMessage(jid, reply)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error sending response: %s", err), "jid", jid)
+		return
+	}
+	slog.Info(result, "jid", jid)
+}
@@ -2,15 +2,16 @@ package whatsapp
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"time"
 
-	"github.com/felipem1210/freetalkbot/packages/assistants"
+	"github.com/felipem1210/freetalkbot/packages/channels"
 	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/stt"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mdp/qrterminal"
 	"go.mau.fi/whatsmeow"
@@ -24,11 +25,7 @@ import (
 
 var (
 	whatsappClient *whatsmeow.Client
-	openaiClient   common.OpenaiClient
-	language       string
-	transcription  string
-	jid            string
-	err            error
+	sttEngine      stt.STT
 )
 
 func getEventHandler() func(interface{}) {
@@ -41,42 +38,44 @@ func getEventHandler() func(interface{}) {
 }
 
 func handleMessageEvent(v *events.Message) {
-	messageBody := v.Message.GetConversation()
-	jid = parseJid(v.Info.Sender.String())
-
-	if messageBody != "" {
-		slog.Info("Received text message", "jid", jid)
-	} else if audioMessage := v.Message.GetAudioMessage(); audioMessage != nil {
-		slog.Info("Received audio message", "jid", jid)
-		transcription, err = transcribeAudio(audioMessage, v.Info.ID)
-		messageBody = transcription
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error transcribing audio message: %s", err), "jid", jid)
-			return
-		}
+	jid := parseJid(v.Info.Sender.String())
+	// The messages of a chat are answered one at a time, the others are not blocked
+	defer conversations.Lock(jid)()
+	receivedAudio := v.Message.GetAudioMessage() != nil
+	previous, _ := conversations.Get(jid)
+	if v.Info.ID != "" && previous.lastMessageId == v.Info.ID {
+		slog.Debug(fmt.Sprintf("message %s already answered", v.Info.ID), "jid", jid)
+		return
 	}
-	slog.Debug(fmt.Sprintf("message received: %s", messageBody), "jid", jid)
 
-	language = common.DetectLanguage(messageBody)
-	slog.Debug(fmt.Sprintf("detected language: %s", language), "sender", jid)
+	message, err := readMessage(v, jid)
+	if errors.Is(err, errUnsupportedMessage) {
+		slog.Info("Received unsupported message", "jid", jid)
+		sendUnsupportedReply(jid, previous.Language)
+		return
+	} else if err != nil {
+		slog.Error(fmt.Sprintf("Error reading message: %s", err), "jid", jid)
+		return
+	}
+	if message.Empty() {
+		return
+	}
+	slog.Debug(fmt.Sprintf("message received: %s", message.Content()), "jid", jid)
 
-	responses, err := assistants.HandleAssistant(language, jid, messageBody)
+	current := conversation{Conversation: previous.Conversation, jid: jid, lastMessageId: v.Info.ID, receivedAudio: receivedAudio}
+	save := func() { conversations.Save(jid, current) }
+	responses, err := current.Ask(context.Background(), jid, "", message, save)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error receiving response from assistant %s: %s", os.Getenv("ASSISTANT_TOOL"), err), "jid", jid)
+		slog.Error(err.Error(), "jid", jid)
 		return
 	}
-
-	slog.Debug(fmt.Sprintf("response from %v: %v", os.Getenv("ASSISTANT_TOOL"), responses), "jid", jid)
-	handleResponses(responses)
+	handleResponses(jid, current.Language, responses, replyWithVoice(receivedAudio))
 }
 
-func handleResponses(responses common.Responses) {
+func handleResponses(jid string, language string, responses common.Responses, voice bool) {
 	for _, r := range responses {
-		result, error := sendWhatsappMessage(r.RecipientId, r.Text)
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error sending response: %s", error), "jid", jid)
-		} else {
-			slog.Info(result, "jid", r.RecipientId)
+		if err := sendResponse(r.RecipientId, language, r, voice); err != nil {
+			slog.Error(fmt.Sprintf("Error sending response: %s", err), "jid", jid)
 		}
 	}
 }
@@ -96,17 +95,14 @@ func sendWhatsappMessage(jidStr string, message string) (string, error) {
 	return fmt.Sprintf("Message sent to %s", jidStr), nil
 }
 
-func transcribeAudio(audioMessage *waE2E.AudioMessage, messageId string) (string, error) {
-	mediaKeyHex := hex.EncodeToString(audioMessage.GetMediaKey())
-	if err := downloadAudio(audioMessage.GetURL(), common.AudioEncPath); err != nil {
-		return "", err
-	}
-	audioFilePath := fmt.Sprintf("%s%s.ogg", common.AudioDir, messageId)
-	if err := decryptAudioFile(common.AudioEncPath, audioFilePath, mediaKeyHex); err != nil {
-		return "", err
+// transcribeAudio downloads and decrypts the voice note in memory and transcribes it
+func transcribeAudio(audioMessage *waE2E.AudioMessage, messageId string, jid string) (string, error) {
+	data, err := whatsappClient.Download(audioMessage)
+	if err != nil {
+		return "", fmt.Errorf("failed to download audio: %w", err)
 	}
 
-	transcription, err = common.TranscribeAudio(audioFilePath, nil, openaiClient)
+	transcription, err := sttEngine.Transcribe(context.Background(), stt.Audio{Data: data, FileName: messageId + ".ogg"}, "")
 	if err != nil {
 		return "", err
 	}
@@ -115,68 +111,106 @@ func transcribeAudio(audioMessage *waE2E.AudioMessage, messageId string) (string
 	return transcription, nil
 }
 
-func InitializeServer() {
+// Server is the whatsapp channel, it acts as a WhatsApp web client of the paired account
+type Server struct {
+	client   *whatsmeow.Client
+	callback *http.Server
+}
+
+// New creates the whatsapp channel with the shared engines, the TTS engine is needed when the responses
+// can be sent as voice notes
+func New(engines channels.Engines) (*Server, error) {
+	sttEngine = engines.STT
+	voiceReplyMode = VoiceReplyMode()
+	if voiceReplyMode != VoiceReplyNever {
+		if engines.TTS == nil {
+			return nil, fmt.Errorf("WHATSAPP_VOICE_REPLY=%s needs a TTS engine", voiceReplyMode)
+		}
+		ttsEngine = engines.TTS
+	}
+
 	sqlDbFilePath := common.DataDir + os.Getenv("SQL_DB_FILE_NAME")
 	dbLog := waLog.Stdout("Database", "INFO", true)
 
 	container, err := sqlstore.New("sqlite3", "file:"+sqlDbFilePath+"?_foreign_keys=on", dbLog)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to initialize SQL store: %v", err))
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to initialize SQL store: %w", err)
 	}
 
 	deviceStore, err := container.GetFirstDevice()
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to get device: %v", err))
-		os.Exit(1)
-	}
-
-	if os.Getenv("STT_TOOL") == "whisper" {
-		openaiClient = common.CreateOpenAiClient()
+		return nil, fmt.Errorf("failed to get device: %w", err)
 	}
 
 	clientLog := waLog.Stdout("Client", "INFO", true)
 	whatsappClient = whatsmeow.NewClient(deviceStore, clientLog)
 	whatsappClient.AddEventHandler(getEventHandler())
-	handleClientConnection(whatsappClient)
+	return &Server{client: whatsappClient, callback: newCallbackServer()}, nil
 }
 
-func handleClientConnection(client *whatsmeow.Client) {
-	if client.Store.ID == nil {
-		qrChan, _ := client.GetQRChannel(context.Background())
-		if err := client.Connect(); err != nil {
-			slog.Error(fmt.Sprintf("Failed to connect: %v", err))
-			os.Exit(1)
-		}
-		pairPhoneNumber, varExists := os.LookupEnv("PAIR_PHONE_NUMBER")
-		if varExists {
-			if code, err := client.PairPhone(pairPhoneNumber, true, whatsmeow.PairClientChrome, "Chrome (MacOS)"); err != nil {
-				slog.Error(fmt.Sprintf("Failed to pair phone: %v", err))
-				os.Exit(1)
-			} else {
-				slog.Info(fmt.Sprintf("Pairing code: %s", code))
-			}
-		}
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
-				slog.Info(fmt.Sprintf("QR code: %s", evt.Code))
-			} else {
-				slog.Info(fmt.Sprintf("Login event: %s", evt.Event))
-			}
+func (srv *Server) Name() string {
+	return "whatsapp"
+}
+
+// Start connects to WhatsApp, pairing the account the first time, and serves the callbacks of the assistant
+// until ctx is done
+func (srv *Server) Start(ctx context.Context) error {
+	callbackErr := make(chan error, 1)
+	go func() {
+		slog.Info(fmt.Sprintf("Starting callback server on %s", srv.callback.Addr))
+		if err := srv.callback.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			callbackErr <- fmt.Errorf("callback server failed: %w", err)
 		}
-	} else {
+	}()
+
+	if err := connect(ctx, srv.client); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-callbackErr:
+		return err
+	}
+}
+
+// Stop shuts down the callback server and disconnects from WhatsApp
+func (srv *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := srv.callback.Shutdown(ctx)
+	srv.client.Disconnect()
+	return err
+}
+
+// connect connects the client, showing the QR code or the pairing code when the account is not paired yet
+func connect(ctx context.Context, client *whatsmeow.Client) error {
+	if client.Store.ID != nil {
 		if err := client.Connect(); err != nil {
-			slog.Error(fmt.Sprintf("Failed to connect: %v", err))
-			os.Exit(1)
+			return fmt.Errorf("failed to connect: %w", err)
 		}
+		return nil
 	}
-	waitForShutdown(client)
-}
 
-func waitForShutdown(client *whatsmeow.Client) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-	client.Disconnect()
+	qrChan, _ := client.GetQRChannel(ctx)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	pairPhoneNumber, varExists := os.LookupEnv("PAIR_PHONE_NUMBER")
+	if varExists {
+		if code, err := client.PairPhone(pairPhoneNumber, true, whatsmeow.PairClientChrome, "Chrome (MacOS)"); err != nil {
+			return fmt.Errorf("failed to pair phone: %w", err)
+		} else {
+			slog.Info(fmt.Sprintf("Pairing code: %s", code))
+		}
+	}
+	for evt := range qrChan {
+		if evt.Event == "code" {
+			qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
+			slog.Info(fmt.Sprintf("QR code: %s", evt.Code))
+		} else {
+			slog.Info(fmt.Sprintf("Login event: %s", evt.Event))
+		}
+	}
+	return nil
 }
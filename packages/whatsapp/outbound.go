@@ -0,0 +1,169 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// listButtonText opens the list of options, translated to the language of the user
+const listButtonText = "Options"
+
+// sendResponse renders the response of the assistant as WhatsApp messages. The image and the attachment are sent
+// as media, and the buttons as a list message when WHATSAPP_LIST_MESSAGES is true or as numbered options otherwise.
+// The user can answer with the number or the title of the option.
+func sendResponse(jid string, language string, r common.Response, voice bool) error {
+	if len(r.Buttons) > 0 {
+		conversations.Update(jid, func(c *conversation) { c.Buttons = r.Buttons })
+	}
+	listMessage := len(r.Buttons) > 0 && os.Getenv("WHATSAPP_LIST_MESSAGES") == "true"
+	var errs []error
+	logResult := func(result string, err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		slog.Info(result, "jid", jid)
+	}
+
+	text := r.Text
+	if len(r.Buttons) > 0 && !listMessage && !voice {
+		text = r.Menu()
+	}
+	if r.Image != "" {
+		caption := text
+		if voice || listMessage {
+			caption = ""
+		}
+		result, err := sendWhatsappMedia(jid, common.Attachment{Type: "image", URL: r.Image}, caption)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error sending image, sending its URL instead: %s", err), "jid", jid)
+			result, err = sendWhatsappMessage(jid, r.Image)
+		} else if caption != "" {
+			text = ""
+		}
+		logResult(result, err)
+	}
+
+	switch {
+	case listMessage:
+		if voice && r.Text != "" {
+			logResult(sendReply(jid, r.Text, language, voice))
+		}
+		logResult(sendWhatsappList(jid, language, r))
+	case text != "":
+		logResult(sendReply(jid, text, language, voice))
+		if voice && len(r.Buttons) > 0 {
+			logResult(sendWhatsappMessage(jid, common.Response{Buttons: r.Buttons}.Menu()))
+		}
+	}
+
+	if r.Attachment != nil {
+		result, err := sendWhatsappMedia(jid, *r.Attachment, "")
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error sending attachment, sending its URL instead: %s", err), "jid", jid)
+			result, err = sendWhatsappMessage(jid, r.Attachment.URL)
+		}
+		logResult(result, err)
+	}
+	if r.Custom != nil {
+		slog.Debug(fmt.Sprintf("custom payload can't be shown in whatsapp: %v", r.Custom), "jid", jid)
+	}
+	return errors.Join(errs...)
+}
+
+// sendWhatsappMedia downloads the file of the attachment and sends it as image or document
+func sendWhatsappMedia(jidStr string, attachment common.Attachment, caption string) (string, error) {
+	jid, err := types.ParseJID(jidStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %v", jidStr)
+	}
+	ctx := context.Background()
+	data, mimeType, err := common.DownloadFile(ctx, attachment.URL, maxMediaSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", attachment.URL, err)
+	}
+
+	message := &waE2E.Message{}
+	if attachment.Type == "image" && strings.HasPrefix(mimeType, "image/") {
+		uploaded, err := whatsappClient.Upload(ctx, data, whatsmeow.MediaImage)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload image: %w", err)
+		}
+		message.ImageMessage = &waE2E.ImageMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}
+		if caption != "" {
+			message.ImageMessage.Caption = proto.String(caption)
+		}
+	} else {
+		uploaded, err := whatsappClient.Upload(ctx, data, whatsmeow.MediaDocument)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload document: %w", err)
+		}
+		fileName := attachment.FileName
+		if fileName == "" {
+			fileName = path.Base(strings.SplitN(attachment.URL, "?", 2)[0])
+		}
+		message.DocumentMessage = &waE2E.DocumentMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			FileName:      proto.String(fileName),
+			Title:         proto.String(fileName),
+		}
+		if caption != "" {
+			message.DocumentMessage.Caption = proto.String(caption)
+		}
+	}
+
+	if _, err = whatsappClient.SendMessage(ctx, jid, message); err != nil {
+		return "", fmt.Errorf("failed to send media: %v", err)
+	}
+	return fmt.Sprintf("Media sent to %s", jidStr), nil
+}
+
+// sendWhatsappList sends the buttons as a list message. The ID of each row is the number of the option.
+func sendWhatsappList(jidStr string, language string, r common.Response) (string, error) {
+	jid, err := types.ParseJID(jidStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %v", jidStr)
+	}
+	rows := make([]*waE2E.ListMessage_Row, len(r.Buttons))
+	for i, b := range r.Buttons {
+		rows[i] = &waE2E.ListMessage_Row{Title: proto.String(b.Title), RowID: proto.String(strconv.Itoa(i + 1))}
+	}
+	_, err = whatsappClient.SendMessage(context.Background(), jid, &waE2E.Message{
+		ListMessage: &waE2E.ListMessage{
+			Description: proto.String(r.Text),
+			ButtonText:  proto.String(common.TranslateFromEnglish(listButtonText, language)),
+			ListType:    waE2E.ListMessage_SINGLE_SELECT.Enum(),
+			Sections:    []*waE2E.ListMessage_Section{{Rows: rows}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send list: %v", err)
+	}
+	return fmt.Sprintf("List sent to %s", jidStr), nil
+}
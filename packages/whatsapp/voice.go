@@ -0,0 +1,107 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/felipem1210/freetalkbot/packages/common"
+	"github.com/felipem1210/freetalkbot/packages/tts"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Values of WHATSAPP_VOICE_REPLY
+const (
+	VoiceReplyAlways = "always"
+	VoiceReplyNever  = "never"
+	VoiceReplyMirror = "mirror"
+)
+
+var (
+	voiceReplyMode = VoiceReplyNever
+	ttsEngine      tts.TTS
+)
+
+// VoiceReplyMode returns the mode configured in WHATSAPP_VOICE_REPLY, never when it is not set
+func VoiceReplyMode() string {
+	if mode := os.Getenv("WHATSAPP_VOICE_REPLY"); mode != "" {
+		return mode
+	}
+	return VoiceReplyNever
+}
+
+// replyWithVoice tells if the response must be sent as a voice note, receivedAudio is true when the user sent a voice note
+func replyWithVoice(receivedAudio bool) bool {
+	switch voiceReplyMode {
+	case VoiceReplyAlways:
+		return true
+	case VoiceReplyMirror:
+		return receivedAudio
+	default:
+		return false
+	}
+}
+
+// sendReply sends the text as voice note when voice is true, falling back to a text message if it fails
+func sendReply(jid string, text string, language string, voice bool) (string, error) {
+	if voice {
+		result, err := sendWhatsappVoiceNote(context.Background(), jid, text, language)
+		if err == nil {
+			return result, nil
+		}
+		slog.Error(fmt.Sprintf("Error sending voice note, sending text instead: %s", err), "jid", jid)
+	}
+	return sendWhatsappMessage(jid, text)
+}
+
+// sendWhatsappVoiceNote synthesizes the text and sends it as a voice note
+func sendWhatsappVoiceNote(ctx context.Context, jidStr string, text string, language string) (string, error) {
+	jid, err := types.ParseJID(jidStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid JID: %v", jidStr)
+	}
+
+	pcm, sampleRate, err := ttsEngine.Synthesize(ctx, text, language)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate audio: %w", err)
+	}
+	audioData, err := encodeOggOpus(ctx, pcm, sampleRate)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audio: %w", err)
+	}
+
+	uploaded, err := whatsappClient.Upload(ctx, audioData, whatsmeow.MediaAudio)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload audio: %w", err)
+	}
+	_, err = whatsappClient.SendMessage(ctx, jid, &waE2E.Message{
+		AudioMessage: &waE2E.AudioMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String("audio/ogg; codecs=opus"),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			Seconds:       proto.Uint32(uint32(len(pcm) / 2 / sampleRate)),
+			PTT:           proto.Bool(true),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send voice note: %v", err)
+	}
+	return fmt.Sprintf("Voice note sent to %s", jidStr), nil
+}
+
+// encodeOggOpus converts PCM 16bit signed linear mono audio to Ogg/Opus, the format of WhatsApp voice notes
+func encodeOggOpus(ctx context.Context, pcm []byte, sampleRate int) ([]byte, error) {
+	return common.ExecuteCommandContext(ctx, bytes.NewReader(pcm), "ffmpeg", "-loglevel", "error",
+		"-f", "s16le", "-ar", strconv.Itoa(sampleRate), "-ac", "1", "-i", "pipe:0",
+		"-c:a", "libopus", "-b:a", "32k", "-application", "voip", "-f", "ogg", "pipe:1")
+}